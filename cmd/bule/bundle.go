@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+
+	"github.com/lumoslabs/vestibule/pkg/environ/providers/bundle"
+)
+
+// formatBundle writes the secrets encrypted with NaCl secretbox, for the bundle provider to decrypt
+const formatBundle = "bundle"
+
+// sealBundle encrypts the secrets with the --bundle-key
+func sealBundle(secrets map[string]string) ([]byte, error) {
+	if *bundleKey == "" {
+		return nil, fmt.Errorf("--bundle-key is required for the bundle format")
+	}
+	key, er := bundle.LoadKey(*bundleKey)
+	if er != nil {
+		return nil, er
+	}
+	return bundle.Seal(key, secrets)
+}
+
+// sameBundle is true if the bundle at path decrypts with the --bundle-key to the secrets
+func sameBundle(path string, secrets map[string]string) bool {
+	key, er := bundle.LoadKey(*bundleKey)
+	if er != nil {
+		return false
+	}
+	data, er := ioutil.ReadFile(path)
+	if er != nil {
+		return false
+	}
+	current, _, er := bundle.Open(key, data)
+	return er == nil && reflect.DeepEqual(current, secrets)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+)
+
+const (
+	valuesMask = "mask"
+	valuesHash = "hash"
+
+	maskedValue = "***"
+)
+
+// diff compares the gathered secrets with the existing file or the secrets from the --against providers, writing
+// the added, removed and changed keys to w. Values are masked or hashed, never shown. Returns true if there are
+// differences.
+func diff(w io.Writer) (bool, error) {
+	var old map[string]string
+	switch {
+	case len(*diffAgainst) > 0 && *diffFile != "":
+		return false, fmt.Errorf("give either a file or --against, not both")
+	case len(*diffAgainst) > 0:
+		against, er := gather(*diffAgainst)
+		if er != nil {
+			return false, er
+		}
+		old = against.Map()
+	case *diffFile != "":
+		f := *format
+		if f == "" {
+			f = detectFormat(*diffFile)
+		}
+		m, er := readOutput(*diffFile, f)
+		if er != nil {
+			return false, er
+		}
+		old = m
+	default:
+		return false, fmt.Errorf("a file or --against is required")
+	}
+
+	gathered, er := gather(*providers)
+	if er != nil {
+		return false, er
+	}
+	return changes(w, old, gathered.Map(), *diffValues), nil
+}
+
+// changes writes the keys added, removed and changed from old to secrets to w, with values shown as given by the
+// values mode. Returns true if there are any.
+func changes(w io.Writer, old, secrets map[string]string, values string) bool {
+	changed := false
+	keys := sortedKeys(merged(old, secrets))
+	for _, k := range keys {
+		ov, inOld := old[k]
+		nv, inNew := secrets[k]
+		switch {
+		case !inOld:
+			fmt.Fprintf(w, "+ %s %s\n", k, showValue(nv, values))
+		case !inNew:
+			fmt.Fprintf(w, "- %s %s\n", k, showValue(ov, values))
+		case ov != nv:
+			fmt.Fprintf(w, "~ %s %s -> %s\n", k, showValue(ov, values), showValue(nv, values))
+		default:
+			continue
+		}
+		changed = true
+	}
+	return changed
+}
+
+// readOutput reads the secrets from a file in format, or from a directory written with the dir format
+func readOutput(path, format string) (map[string]string, error) {
+	if format == formatDir {
+		m := readDir(path)
+		if m == nil {
+			return nil, fmt.Errorf("no secrets found in %s", path)
+		}
+		return m, nil
+	}
+
+	data, er := ioutil.ReadFile(path)
+	if er != nil {
+		if os.IsNotExist(er) {
+			return map[string]string{}, nil
+		}
+		return nil, er
+	}
+	return environ.Unmarshal(format, data)
+}
+
+func showValue(v, values string) string {
+	if values == valuesHash {
+		return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(v)))[:19]
+	}
+	return maskedValue
+}
+
+func merged(maps ...map[string]string) map[string]string {
+	m := make(map[string]string)
+	for _, mm := range maps {
+		for k, v := range mm {
+			m[k] = v
+		}
+	}
+	return m
+}
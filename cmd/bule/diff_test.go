@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChanges(t *testing.T) {
+	old := map[string]string{"KEPT": "same-value", "GONE": "gone-value", "CHANGED": "old-value"}
+	secrets := map[string]string{"KEPT": "same-value", "ADDED": "added-value", "CHANGED": "new-value"}
+
+	var buf bytes.Buffer
+	assert.True(t, changes(&buf, old, secrets, valuesMask))
+	assert.Equal(t, "+ ADDED ***\n~ CHANGED *** -> ***\n- GONE ***\n", buf.String())
+
+	buf.Reset()
+	assert.True(t, changes(&buf, old, secrets, valuesHash))
+	assert.Contains(t, buf.String(), "+ ADDED sha256:")
+	assert.Equal(t, showValue("new-value", valuesHash), showValue("new-value", valuesHash))
+	assert.NotEqual(t, showValue("old-value", valuesHash), showValue("new-value", valuesHash))
+	for _, v := range []string{"same-value", "gone-value", "old-value", "new-value", "added-value"} {
+		assert.NotContains(t, buf.String(), v, "values are never shown")
+	}
+
+	buf.Reset()
+	assert.False(t, changes(&buf, old, old, valuesMask))
+	assert.Empty(t, buf.String())
+}
+
+func TestDiff(t *testing.T) {
+	dir, er := ioutil.TempDir("", "bule-test-")
+	require.NoError(t, er)
+	defer os.RemoveAll(dir)
+	defer useStub()()
+	defer func(f, file, values string, against []string) {
+		*format, *diffFile, *diffValues, *diffAgainst = f, file, values, against
+	}(*format, *diffFile, *diffValues, *diffAgainst)
+	*format, *diffValues, *diffAgainst = "", valuesMask, nil
+
+	path := filepath.Join(dir, "app.env")
+	require.NoError(t, ioutil.WriteFile(path, []byte("A=\"1\"\nB=\"2\"\n"), 0600))
+	*diffFile = path
+
+	run := func() (bool, string, error) {
+		var buf bytes.Buffer
+		changed, er := diff(&buf)
+		return changed, buf.String(), er
+	}
+
+	setStub(map[string]string{"A": "1", "B": "2"}, nil)
+	changed, out, er := run()
+	require.NoError(t, er)
+	assert.False(t, changed, "no differences exit 0")
+	assert.Empty(t, out)
+
+	setStub(map[string]string{"A": "1", "B": "secret-b", "C": "secret-c"}, nil)
+	changed, out, er = run()
+	require.NoError(t, er)
+	assert.True(t, changed, "differences exit 1")
+	assert.Equal(t, "~ B *** -> ***\n+ C ***\n", out)
+
+	*diffFile = filepath.Join(dir, "missing.env")
+	changed, out, er = run()
+	require.NoError(t, er, "a missing file has no secrets yet")
+	assert.True(t, changed)
+	assert.Equal(t, "+ A ***\n+ B ***\n+ C ***\n", out)
+
+	*diffFile = filepath.Join(dir, "app.json")
+	require.NoError(t, ioutil.WriteFile(*diffFile, []byte("{not json"), 0600))
+	_, out, er = run()
+	assert.Error(t, er, "an unparseable file exits 2")
+	assert.Empty(t, out)
+
+	*diffFile, *format = dir, formatDir
+	_, _, er = run()
+	assert.Error(t, er, "a directory without a snapshot")
+	*format = ""
+
+	*diffFile = path
+	setStub(nil, errors.New("vault is down"))
+	_, out, er = run()
+	assert.Error(t, er, "a provider failure exits 2")
+	assert.Empty(t, out)
+
+	setStub(map[string]string{"A": "1"}, nil)
+	*diffAgainst = []string{"stub"}
+	_, _, er = run()
+	assert.Error(t, er, "both a file and --against")
+
+	*diffFile = ""
+	changed, out, er = run()
+	require.NoError(t, er)
+	assert.False(t, changed)
+	assert.Empty(t, out)
+
+	*diffAgainst = nil
+	_, _, er = run()
+	assert.Error(t, er, "neither a file nor --against")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+const (
+	// formatDir writes a directory with a file per secret, laid out the way the kubelet writes secret volumes
+	formatDir = "dir"
+
+	// dataDirName is the symlink to the current snapshot of the secrets
+	dataDirName = "..data"
+	// dataDirTmpName is the symlink renamed over dataDirName to swap snapshots atomically
+	dataDirTmpName = "..data_tmp"
+
+	snapshotDirMode = os.FileMode(0755)
+)
+
+// checkDirName refuses names which would escape the directory or clash with the snapshot layout
+func checkDirName(name string) error {
+	if name == "" || strings.Contains(name, "/") || strings.HasPrefix(name, "..") {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	return nil
+}
+
+// readDir returns the secrets in the current snapshot of the directory, or nil if there is none
+func readDir(dir string) map[string]string {
+	data := filepath.Join(dir, dataDirName)
+	infos, er := ioutil.ReadDir(data)
+	if er != nil {
+		return nil
+	}
+
+	m := make(map[string]string, len(infos))
+	for _, fi := range infos {
+		b, er := ioutil.ReadFile(filepath.Join(data, fi.Name()))
+		if er != nil {
+			return nil
+		}
+		m[fi.Name()] = string(b)
+	}
+	return m
+}
+
+// applyDir gives the current snapshot of the directory and every secret in it their mode and owner, as stageDir
+// would have
+func applyDir(dir string, o *fileOpts) error {
+	data := filepath.Join(dir, dataDirName)
+	infos, er := ioutil.ReadDir(data)
+	if er != nil {
+		return er
+	}
+	for _, fi := range infos {
+		if er := o.apply(filepath.Join(data, fi.Name())); er != nil {
+			return er
+		}
+	}
+	if o.uid != -1 || o.gid != -1 {
+		return os.Chown(data, o.uid, o.gid)
+	}
+	return nil
+}
+
+// stageDir writes the secrets to a new timestamped snapshot directory in dir and returns its name
+func stageDir(dir string, secrets map[string]string, o *fileOpts) (string, error) {
+	if er := o.checkDir(filepath.Dir(dir)); er != nil {
+		return "", er
+	}
+	if er := os.MkdirAll(dir, snapshotDirMode); er != nil {
+		return "", er
+	}
+
+	snapshot, er := ioutil.TempDir(dir, time.Now().UTC().Format("..2006_01_02_15_04_05."))
+	if er != nil {
+		return "", er
+	}
+	fail := func(er error) (string, error) {
+		os.RemoveAll(snapshot)
+		return "", er
+	}
+
+	if er := os.Chmod(snapshot, snapshotDirMode); er != nil {
+		return fail(er)
+	}
+	for name, v := range secrets {
+		if er := checkDirName(name); er != nil {
+			return fail(er)
+		}
+		if er := ioutil.WriteFile(filepath.Join(snapshot, name), []byte(v), o.mode); er != nil {
+			return fail(er)
+		}
+		if er := o.apply(filepath.Join(snapshot, name)); er != nil {
+			return fail(er)
+		}
+	}
+	if o.uid != -1 || o.gid != -1 {
+		if er := os.Chown(snapshot, o.uid, o.gid); er != nil {
+			return fail(er)
+		}
+	}
+	if er := syncDir(snapshot); er != nil {
+		return fail(er)
+	}
+	return snapshot, nil
+}
+
+// commitDir swaps the ..data symlink over to the staged snapshot, links every secret in the snapshot from the
+// directory and removes the previous snapshot and the links to secrets which no longer exist
+func commitDir(dir, snapshot string) error {
+	data := filepath.Join(dir, dataDirName)
+	previous, _ := os.Readlink(data)
+
+	tmp := filepath.Join(dir, dataDirTmpName)
+	os.Remove(tmp)
+	if er := os.Symlink(filepath.Base(snapshot), tmp); er != nil {
+		os.RemoveAll(snapshot)
+		return er
+	}
+	if er := os.Rename(tmp, data); er != nil {
+		os.Remove(tmp)
+		os.RemoveAll(snapshot)
+		return er
+	}
+	log.Debugf("Swapped secrets snapshot. dir=%s snapshot=%s", dir, filepath.Base(snapshot))
+
+	infos, er := ioutil.ReadDir(snapshot)
+	if er != nil {
+		return er
+	}
+	current := make(map[string]bool, len(infos))
+	for _, fi := range infos {
+		current[fi.Name()] = true
+		link := filepath.Join(dir, fi.Name())
+		if _, er := os.Lstat(link); er == nil {
+			continue
+		}
+		if er := os.Symlink(filepath.Join(dataDirName, fi.Name()), link); er != nil {
+			return er
+		}
+	}
+
+	entries, er := ioutil.ReadDir(dir)
+	if er != nil {
+		return er
+	}
+	for _, fi := range entries {
+		name := fi.Name()
+		if !strings.HasPrefix(name, "..") && fi.Mode()&os.ModeSymlink != 0 && !current[name] {
+			os.Remove(filepath.Join(dir, name))
+		}
+	}
+	if previous != "" && previous != filepath.Base(snapshot) {
+		os.RemoveAll(filepath.Join(dir, previous))
+	}
+	return syncDir(dir)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteDir(t *testing.T) {
+	parent, er := ioutil.TempDir("", "bule-test-")
+	require.NoError(t, er)
+	defer os.RemoveAll(parent)
+	dir := filepath.Join(parent, "secrets")
+
+	o := &output{Path: dir, Format: formatDir}
+	require.NoError(t, o.init(&output{Mode: "0600"}, false))
+	assert.Nil(t, readDir(dir), "no snapshot yet")
+
+	snapshot := func() string {
+		name, er := os.Readlink(filepath.Join(dir, dataDirName))
+		require.NoError(t, er)
+		return name
+	}
+	read := func(name string) string {
+		b, er := ioutil.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, er)
+		return string(b)
+	}
+
+	written, er := writeOutputs([]*output{o}, map[string]string{"A": "1", "B": "2"})
+	require.NoError(t, er)
+	assert.Equal(t, []string{dir}, written)
+	first := snapshot()
+	assertOnly(t, dir, dataDirName, first, "A", "B")
+	assert.Equal(t, "1", read("A"))
+	assert.Equal(t, "2", read("B"))
+	link, er := os.Readlink(filepath.Join(dir, "A"))
+	require.NoError(t, er)
+	assert.Equal(t, filepath.Join(dataDirName, "A"), link, "keys link through the ..data symlink")
+	fi, er := os.Stat(filepath.Join(dir, first, "A"))
+	require.NoError(t, er)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	assert.Equal(t, map[string]string{"A": "1", "B": "2"}, readDir(dir))
+
+	written, er = writeOutputs([]*output{o}, map[string]string{"A": "1", "B": "2"})
+	require.NoError(t, er)
+	assert.Empty(t, written, "an unchanged directory is not rewritten")
+	assert.Equal(t, first, snapshot())
+
+	written, er = writeOutputs([]*output{o}, map[string]string{"A": "3", "C": "4"})
+	require.NoError(t, er)
+	assert.Equal(t, []string{dir}, written)
+	second := snapshot()
+	assert.NotEqual(t, first, second)
+	assertOnly(t, dir, dataDirName, second, "A", "C")
+	assert.Equal(t, "3", read("A"))
+	assert.Equal(t, "4", read("C"))
+	assert.Equal(t, map[string]string{"A": "3", "C": "4"}, readDir(dir))
+}
+
+func TestStageDirInvalidName(t *testing.T) {
+	dir, er := ioutil.TempDir("", "bule-test-")
+	require.NoError(t, er)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"", "a/b", "..data", "../escape"} {
+		assert.Errorf(t, checkDirName(name), "%q", name)
+	}
+	assert.NoError(t, checkDirName("tls.key"))
+
+	o, er := newFileOpts("0600", "", "", false)
+	require.NoError(t, er)
+	_, er = stageDir(dir, map[string]string{"A": "1", "../escape": "2"}, o)
+	assert.Error(t, er)
+	assertOnly(t, dir)
+}
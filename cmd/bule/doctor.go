@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+)
+
+// doctor runs the checks of every provider, writing a line per step and a hint under each failure to w. Returns
+// false if any check failed.
+func doctor(w io.Writer, providers []string) bool {
+	ok := true
+	for _, name := range providers {
+		checks, er := environ.Diagnose(name)
+		if er != nil {
+			fmt.Fprintf(w, "FAIL %s: %v\n", name, er)
+			ok = false
+			continue
+		}
+		if len(checks) == 0 {
+			fmt.Fprintf(w, "ok   %s: nothing to check\n", name)
+		}
+
+		for _, c := range checks {
+			if c.Err != nil {
+				ok = false
+				fmt.Fprintf(w, "FAIL %s %s: %s\n", name, c.Step, strings.Join(strings.Fields(c.Err.Error()), " "))
+				if c.Hint != "" {
+					fmt.Fprintf(w, "     %s\n", c.Hint)
+				}
+				continue
+			}
+
+			if c.Detail == "" {
+				fmt.Fprintf(w, "ok   %s %s\n", name, c.Step)
+			} else {
+				fmt.Fprintf(w, "ok   %s %s: %s\n", name, c.Step, c.Detail)
+			}
+		}
+	}
+	return ok
+}
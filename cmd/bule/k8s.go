@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+const (
+	// formatK8sSecret writes a Kubernetes Secret manifest with the secrets base64 encoded in its data
+	formatK8sSecret = "k8s-secret"
+	// formatK8sConfigMap writes a Kubernetes ConfigMap manifest with the secrets in its data
+	formatK8sConfigMap = "k8s-configmap"
+)
+
+var (
+	// k8sNameRE matches DNS-1123 subdomains, which Secret and ConfigMap names must be
+	k8sNameRE = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	// k8sKeyRE matches the keys Secret and ConfigMap data accept
+	k8sKeyRE = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+	// k8sNameInvalidRE matches runs of characters not allowed in names
+	k8sNameInvalidRE = regexp.MustCompile(`[^a-z0-9.-]+`)
+)
+
+type k8sManifest struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   k8sMetadata       `yaml:"metadata"`
+	Type       string            `yaml:"type,omitempty"`
+	Data       map[string]string `yaml:"data"`
+}
+
+type k8sMetadata struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// k8sKinds are the kinds of object written by each Kubernetes manifest format
+var k8sKinds = map[string]string{formatK8sSecret: "Secret", formatK8sConfigMap: "ConfigMap"}
+
+func init() {
+	for format := range k8sKinds {
+		environ.RegisterFormat(format, k8sEncoder(format, ""), k8sDecoder(format), "application/yaml")
+	}
+}
+
+// k8sName returns --k8s-name, or a name derived from the base name of the output file without its extension
+func k8sName(path string) (string, error) {
+	name := *k8sObjectName
+	if name == "" && path != stdoutPath {
+		base := strings.ToLower(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+		name = strings.Trim(k8sNameInvalidRE.ReplaceAllString(base, "-"), "-.")
+	}
+	if name == "" {
+		return "", fmt.Errorf("--k8s-name is required to write a manifest to stdout")
+	}
+	if len(name) > 253 || !k8sNameRE.MatchString(name) {
+		return "", fmt.Errorf("invalid kubernetes name %q", name)
+	}
+	return name, nil
+}
+
+// k8sEncoder returns an Encoder writing the secrets as a manifest of the kind of the format, named name or, if name
+// is empty, --k8s-name
+func k8sEncoder(format, name string) environ.Encoder {
+	return func(w io.Writer, secrets map[string]string) error {
+		n := name
+		if n == "" {
+			var er error
+			if n, er = k8sName(stdoutPath); er != nil {
+				return er
+			}
+		}
+		out, er := renderK8s(format, n, secrets)
+		if er != nil {
+			return er
+		}
+		_, er = w.Write(out)
+		return er
+	}
+}
+
+// renderK8s returns the secrets as a Secret or ConfigMap manifest named name
+func renderK8s(format, name string, secrets map[string]string) ([]byte, error) {
+	m := k8sManifest{
+		APIVersion: "v1",
+		Kind:       k8sKinds[format],
+		Metadata:   k8sMetadata{Name: name, Namespace: *k8sNamespace, Labels: *k8sLabels, Annotations: *k8sAnnotations},
+		Data:       make(map[string]string, len(secrets)),
+	}
+	for k, v := range secrets {
+		if !k8sKeyRE.MatchString(k) {
+			return nil, fmt.Errorf("%q is not a valid kubernetes data key", k)
+		}
+		if format == formatK8sSecret {
+			v = base64.StdEncoding.EncodeToString([]byte(v))
+		}
+		m.Data[k] = v
+	}
+	if format == formatK8sSecret {
+		m.Type = *k8sType
+	}
+	return yaml.Marshal(m)
+}
+
+// seal pipes the manifest through the --k8s-seal command and returns what it writes to stdout
+func seal(manifest []byte) ([]byte, error) {
+	log.Debugf("Sealing manifest. cmd=%s", *k8sSeal)
+	cmd := exec.Command("/bin/sh", "-c", *k8sSeal)
+	cmd.Stdin = bytes.NewReader(manifest)
+	cmd.Stderr = os.Stderr
+	out, er := cmd.Output()
+	if er != nil {
+		return nil, fmt.Errorf("seal command failed: %v", er)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("seal command wrote nothing")
+	}
+	return out, nil
+}
+
+// k8sDecoder returns a Decoder reading the data of a manifest of the kind of the format
+func k8sDecoder(format string) environ.Decoder {
+	return func(data []byte) (map[string]string, error) {
+		return readK8s(format, data)
+	}
+}
+
+// readK8s returns the data of a Secret or ConfigMap manifest, decoding the base64 of a Secret. Other kinds, such as
+// the SealedSecret --k8s-seal writes, are an error as their data cannot be read back.
+func readK8s(format string, data []byte) (map[string]string, error) {
+	var m k8sManifest
+	if er := yaml.Unmarshal(data, &m); er != nil {
+		return nil, er
+	}
+	if m.Kind != k8sKinds[format] {
+		return nil, fmt.Errorf("cannot read the data of a %s manifest as a %s", m.Kind, k8sKinds[format])
+	}
+
+	out := make(map[string]string, len(m.Data))
+	for k, v := range m.Data {
+		if format == formatK8sSecret {
+			b, er := base64.StdEncoding.DecodeString(v)
+			if er != nil {
+				return nil, fmt.Errorf("invalid base64 in %s: %v", k, er)
+			}
+			v = string(b)
+		}
+		out[k] = v
+	}
+	return out, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+)
+
+func TestK8sName(t *testing.T) {
+	tests := []struct {
+		flag, path, want string
+	}{
+		{"", "deploy/app-secrets.yaml", "app-secrets"},
+		{"", "/tmp/My_App.Secrets.yml", "my-app.secrets"},
+		{"", "__app__.yaml", "app"},
+		{"explicit", "deploy/app-secrets.yaml", "explicit"},
+		{"explicit", stdoutPath, "explicit"},
+	}
+	defer func(name string) { *k8sObjectName = name }(*k8sObjectName)
+	for _, tt := range tests {
+		*k8sObjectName = tt.flag
+		name, er := k8sName(tt.path)
+		if assert.NoErrorf(t, er, tt.path) {
+			assert.Equalf(t, tt.want, name, tt.path)
+		}
+	}
+
+	*k8sObjectName = ""
+	_, er := k8sName(stdoutPath)
+	assert.Error(t, er, "stdout needs --k8s-name")
+	_, er = k8sName("___.yaml")
+	assert.Error(t, er)
+	*k8sObjectName = "Not_Valid"
+	_, er = k8sName("app.yaml")
+	assert.Error(t, er)
+}
+
+func TestRenderK8s(t *testing.T) {
+	defer func(ns, typ string, labels map[string]string) {
+		*k8sNamespace, *k8sType, *k8sLabels = ns, typ, labels
+	}(*k8sNamespace, *k8sType, *k8sLabels)
+	*k8sNamespace, *k8sType = "prod", "Opaque"
+	*k8sLabels = map[string]string{"app": "web"}
+	secrets := map[string]string{"DB_PASS": "hunter2", "tls.key": "line1\nline2"}
+
+	out, er := renderK8s(formatK8sSecret, "app", secrets)
+	require.NoError(t, er)
+	assert.Equal(t, `apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+  namespace: prod
+  labels:
+    app: web
+type: Opaque
+data:
+  DB_PASS: aHVudGVyMg==
+  tls.key: bGluZTEKbGluZTI=
+`, string(out))
+	m, er := readK8s(formatK8sSecret, out)
+	require.NoError(t, er)
+	assert.Equal(t, secrets, m)
+
+	out, er = renderK8s(formatK8sConfigMap, "app", secrets)
+	require.NoError(t, er)
+	assert.Contains(t, string(out), "kind: ConfigMap\n")
+	assert.NotContains(t, string(out), "type:")
+	m, er = readK8s(formatK8sConfigMap, out)
+	require.NoError(t, er)
+	assert.Equal(t, secrets, m)
+
+	_, er = renderK8s(formatK8sSecret, "app", map[string]string{"not valid": "x"})
+	assert.Error(t, er)
+}
+
+func TestReadK8s(t *testing.T) {
+	_, er := readK8s(formatK8sSecret, []byte("kind: Secret\ndata:\n  A: '%%%'\n"))
+	assert.Error(t, er, "invalid base64")
+
+	_, er = readK8s(formatK8sSecret, []byte("kind: ConfigMap\ndata:\n  A: x\n"))
+	assert.Error(t, er, "kind of another format")
+
+	_, er = environ.Unmarshal(formatK8sSecret, []byte("apiVersion: bitnami.com/v1alpha1\nkind: SealedSecret\nspec:\n  encryptedData:\n    A: AgB...\n"))
+	if assert.Error(t, er, "a sealed manifest cannot be read back") {
+		assert.Contains(t, er.Error(), "SealedSecret")
+	}
+}
+
+func TestWriteSealed(t *testing.T) {
+	dir, er := ioutil.TempDir("", "bule-test-")
+	require.NoError(t, er)
+	defer os.RemoveAll(dir)
+
+	defer func(cmd string) { *k8sSeal = cmd }(*k8sSeal)
+	// sealing is not deterministic, like kubeseal's random session keys
+	*k8sSeal = `sed 's/^kind: Secret$/kind: SealedSecret/'; echo "# $$"`
+
+	o := &output{Path: filepath.Join(dir, "app.yaml"), Format: formatK8sSecret}
+	require.NoError(t, o.init(&output{Mode: "0600"}, false))
+
+	written, er := writeOutputs([]*output{o}, map[string]string{"A": "1"})
+	require.NoError(t, er)
+	assert.Equal(t, []string{o.Path}, written)
+	first, er := ioutil.ReadFile(o.Path)
+	require.NoError(t, er)
+	assert.Contains(t, string(first), "kind: SealedSecret\n")
+
+	written, er = writeOutputs([]*output{o}, map[string]string{"A": "1"})
+	require.NoError(t, er)
+	assert.Empty(t, written, "unchanged secrets are not sealed again")
+	again, er := ioutil.ReadFile(o.Path)
+	require.NoError(t, er)
+	assert.Equal(t, first, again)
+
+	written, er = writeOutputs([]*output{o}, map[string]string{"A": "2"})
+	require.NoError(t, er)
+	assert.Equal(t, []string{o.Path}, written)
+
+	*format = formatK8sSecret
+	*diffFile = o.Path
+	defer func() { *format, *diffFile = "", "" }()
+	_, er = diff(ioutil.Discard)
+	assert.Error(t, er, "diff against a sealed manifest")
+}
@@ -18,6 +18,14 @@ func newLogger(level string, writer io.Writer) *zl {
 	return &zl{zlog}
 }
 
+func (l *zl) Warn(msg string) {
+	l.Logger.Warn().Msg(msg)
+}
+
+func (l *zl) Warnf(fmt string, objs ...interface{}) {
+	l.Logger.Warn().Msgf(fmt, objs...)
+}
+
 func (l *zl) Info(msg string) {
 	l.Logger.Info().Msg(msg)
 }
@@ -2,9 +2,13 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
 
 	"github.com/lumoslabs/vestibule/pkg/environ"
+	"github.com/lumoslabs/vestibule/pkg/environ/providers/bundle"
 	"github.com/lumoslabs/vestibule/pkg/environ/providers/dotenv"
 	"github.com/lumoslabs/vestibule/pkg/environ/providers/ejson"
 	"github.com/lumoslabs/vestibule/pkg/environ/providers/sops"
@@ -19,22 +23,70 @@ var (
 		ejson.Name,
 		vault.Name,
 		sops.Name,
+		bundle.Name,
 	}
 
 	app       = kingpin.New("bule", "Write secrets to a file! What could go wrong?").DefaultEnvars()
 	debug     = app.Flag("debug", "Debug output").Short('D').Bool()
 	verbose   = app.Flag("verbose", "Verbose output").Short('v').Bool()
-	format    = app.Flag("format", fmt.Sprintf("Format of the output file. Available formats: %v", environ.Marshallers())).Short('F').Default("json").HintOptions(environ.Marshallers()...).Enum(environ.Marshallers()...)
+	format    = app.Flag("format", fmt.Sprintf("Format of the output file, dir to write a directory with a file per secret, bundle to encrypt the secrets with --bundle-key, or k8s-secret or k8s-configmap for a Kubernetes manifest. Defaults to the format of the file extension, else json. Available formats: %v", formats())).Short('F').HintOptions(formats()...).Enum(formats()...)
 	providers = app.Flag("provider", fmt.Sprintf("Secret provider. Can be used multiple times. Available providers: %v", secretProviders)).Short('p').Default("vault").Strings()
 	upcase    = app.Flag("upcase-var-names", "Upcase environment variable names gathered from secret providers.").Default("true").Bool()
-	filename  = app.Arg("file", "Path of output file").Required().String()
+	protected = app.Flag("protect", "Additional environment variable name (globs allowed) which secret providers may not override. Can be used multiple times.").Strings()
+	policies  = app.Flag("protect-policy", "Policy applied when a secret provider sets a protected name: refuse, warn or allow, optionally scoped as provider=policy. Can be used multiple times.").Default("refuse").Strings()
+
+	writeCmd       = app.Command("write", "Write secrets to a file. write is the default command, so a file named after another command needs it, e.g. bule write list, or a path such as ./list.").Default()
+	mode           = writeCmd.Flag("mode", "Octal permissions of the output file.").Default("0600").String()
+	owner          = writeCmd.Flag("owner", "User name or uid to own the output file.").String()
+	group          = writeCmd.Flag("group", "Group name or gid to own the output file.").String()
+	force          = writeCmd.Flag("force", "Write into a world writable directory.").Bool()
+	manifestFile   = writeCmd.Flag("manifest", "YAML manifest of output files, each with a path, format or template, keys (globs), rename, merge, set_paths, mode, owner and group, all written from a single run of the providers.").ExistingFile()
+	template       = writeCmd.Flag("template", "Render this text/template against the secrets instead of marshalling them. Helpers: secret, default, required, base64Decode, toJSON, indent and env.").ExistingFile()
+	templateStrict = writeCmd.Flag("template-strict", "Fail when the template references an undefined secret.").Bool()
+	merge          = writeCmd.Flag("merge", "Merge secrets into the existing output file, keeping its other keys, comments and order. Supported for json, yaml, toml and dotenv.").Bool()
+	setPaths       = writeCmd.Flag("set-path", "Set a secret at a JSON pointer (/a/b) or dotted YAML path (a.b) in the existing output file, e.g. DB_PASSWORD=/database/password. Can be used multiple times.").PlaceHolder("KEY=PATH").StringMap()
+	watchMode      = writeCmd.Flag("watch", "Keep running, gathering secrets every --interval and rewriting outputs whose content changed.").Bool()
+	interval       = writeCmd.Flag("interval", "How often to gather secrets with --watch.").Default("5m").Duration()
+	jitter         = writeCmd.Flag("jitter", "Random extra time of up to this long added to each --interval.").Default("30s").Duration()
+	signalPidfile  = writeCmd.Flag("signal-pidfile", "Signal the process whose pid is in this file when an output changes.").String()
+	signalProcess  = writeCmd.Flag("signal-process", "Signal every process with this name when an output changes.").String()
+	signalName     = writeCmd.Flag("signal", "Signal sent by --signal-pidfile and --signal-process.").Default("HUP").String()
+	onChange       = writeCmd.Flag("on-change", "Command run with /bin/sh -c when an output changes.").String()
+	readyFile      = writeCmd.Flag("ready-file", "File created once outputs have first been written with --watch.").String()
+	bundleKey      = writeCmd.Flag("bundle-key", "Key of --format=bundle: a file holding 32 bytes, raw or hex or base64 encoded, or keyring:NAME for a user key in the kernel keyring.").PlaceHolder("FILE").String()
+	k8sObjectName  = writeCmd.Flag("k8s-name", "Name of the k8s-secret or k8s-configmap manifest. Defaults to the output file name without its extension.").String()
+	k8sNamespace   = writeCmd.Flag("k8s-namespace", "Namespace of the k8s-secret or k8s-configmap manifest.").String()
+	k8sLabels      = writeCmd.Flag("k8s-label", "Label of the k8s-secret or k8s-configmap manifest. Can be used multiple times.").PlaceHolder("KEY=VALUE").StringMap()
+	k8sAnnotations = writeCmd.Flag("k8s-annotation", "Annotation of the k8s-secret or k8s-configmap manifest. Can be used multiple times.").PlaceHolder("KEY=VALUE").StringMap()
+	k8sType        = writeCmd.Flag("k8s-type", "Type of the k8s-secret manifest.").Default("Opaque").String()
+	k8sSeal        = writeCmd.Flag("k8s-seal", "Command run with /bin/sh -c which reads the manifest on stdin and writes the sealed manifest to stdout, written instead, e.g. kubeseal --format yaml --cert pub.pem.").PlaceHolder("CMD").String()
+	filename       = writeCmd.Arg("file", "Path of output file, or - for stdout").String()
+
+	getCmd = app.Command("get", "Print the raw value of a secret. Exits 1 if it is not found.")
+	getKey = getCmd.Arg("key", "Name of the secret").Required().String()
+
+	listCmd = app.Command("list", "List the names of the secrets with the provider each came from. Values are never shown.")
+
+	diffCmd     = app.Command("diff", "Compare gathered secrets with an existing file, or with a second set of providers. Exits 1 if they differ.")
+	diffFile    = diffCmd.Arg("file", "Existing file, read in --format, or directory written with --format=dir").String()
+	diffAgainst = diffCmd.Flag("against", "Compare with secrets from this provider instead of a file. Can be used multiple times.").Strings()
+	diffValues  = diffCmd.Flag("values", "How changed values are shown: mask, or hash to show a short sha256 of each value.").Default(valuesMask).Enum(valuesMask, valuesHash)
+
+	pushCmd     = app.Command("push", "Write the gathered secrets to a secret store, e.g. bule -p dotenv push --to vault:secret/app.")
+	pushTo      = pushCmd.Flag("to", "Destination as store:path. Supported stores: vault, for a KV v1 or v2 path.").Required().PlaceHolder("vault:PATH").String()
+	pushDryRun  = pushCmd.Flag("dry-run", "Print the changes without writing them.").Bool()
+	pushReplace = pushCmd.Flag("replace", "Replace the secret with the gathered secrets instead of merging them into its existing keys.").Bool()
+	pushCAS     = pushCmd.Flag("cas", "Check-and-set against the version read, failing if the secret changed meanwhile. Needs KV v2, disable with --no-cas for KV v1.").Default("true").Bool()
+	pushValues  = pushCmd.Flag("values", "How changed values are shown: mask, or hash to show a short sha256 of each value.").Default(valuesMask).Enum(valuesMask, valuesHash)
+
+	doctorCmd = app.Command("doctor", "Check the configuration of each provider step by step, printing no values. Exits 1 if any check fails.")
 )
 
 func main() {
 	app.Author(author)
 	app.Version(appVersion())
 	app.HelpFlag.Short('h')
-	kingpin.MustParse(app.Parse(os.Args[1:]))
+	cmd := kingpin.MustParse(app.Parse(stdoutArgs(os.Args[1:])))
 
 	logLevel := "disabled"
 	if *debug {
@@ -46,21 +98,176 @@ func main() {
 	log := newLogger(logLevel, os.Stderr)
 	logger.SetLogger(log)
 
-	secrets := environ.New()
-	secrets.UpcaseKeys = *upcase
-	secrets.Populate(*providers)
-	secrets.SetMarshaller(*format)
+	environ.Protect(*protected...)
 
-	log.Debugf("Writing secrets to file. file=%s fmt=%s", *filename, *format)
-	file, er := os.Create(*filename)
-	defer file.Close()
+	switch cmd {
+	case getCmd.FullCommand(), listCmd.FullCommand():
+		secrets, er := gather(*providers)
+		if secrets == nil {
+			log.Infof("Failed to gather secrets. err=%v", er)
+			os.Exit(1)
+		}
+		if cmd == listCmd.FullCommand() {
+			list(os.Stdout, secrets)
+			return
+		}
+		v, ok := get(secrets, *getKey)
+		if !ok {
+			log.Infof("Secret not found. key=%s", *getKey)
+			os.Exit(1)
+		}
+		fmt.Print(v)
+		return
+	case doctorCmd.FullCommand():
+		if !doctor(os.Stdout, *providers) {
+			os.Exit(1)
+		}
+		return
+	case pushCmd.FullCommand():
+		if er := push(os.Stdout); er != nil {
+			log.Infof("Failed to push secrets. err=%v", er)
+			os.Exit(1)
+		}
+		return
+	case diffCmd.FullCommand():
+		changed, er := diff(os.Stdout)
+		if er != nil {
+			log.Infof("Failed to diff secrets. err=%v", er)
+			os.Exit(2)
+		}
+		if changed {
+			os.Exit(1)
+		}
+		return
+	}
 
+	defaults := &output{Path: *filename, Format: *format, Template: *template, Merge: *merge, SetPaths: *setPaths, Mode: *mode, Owner: *owner, Group: *group}
+	outputs, er := loadOutputs(defaults)
 	if er != nil {
-		log.Infof("Failed to write secrets to file. file=%s err=%v", *filename, er)
+		app.FatalUsage("%v", er)
+	}
+
+	if *watchMode {
+		for _, o := range outputs {
+			if o.Path == stdoutPath {
+				app.FatalUsage("--watch needs an output file, not stdout")
+			}
+		}
+		if er := watch(outputs); er != nil {
+			log.Infof("Failed to watch secrets. err=%v", er)
+			os.Exit(1)
+		}
+		return
+	}
+
+	secrets, er := gather(*providers)
+	if secrets == nil {
+		log.Infof("Failed to gather secrets. err=%v", er)
+		os.Exit(1)
+	}
+	if _, er := writeOutputs(outputs, secrets.Map()); er != nil {
+		log.Infof("Failed to write secrets to file. err=%v", er)
 		os.Exit(1)
 	}
+}
+
+// providerEnv restores the provider configuration consumed by the first gather
+var providerEnv environ.ProviderEnv
+
+// gather returns the secrets from the providers along with any provider failures. The secrets are nil if the
+// Environ could not be set up.
+func gather(providers []string) (*environ.Environ, error) {
+	secrets := environ.New()
+	secrets.UpcaseKeys = *upcase
+	if er := secrets.SetPolicies(*policies); er != nil {
+		return nil, fmt.Errorf("invalid protect policy: %v", er)
+	}
+
+	failed := providerEnv.Populate(secrets, providers)
+	// provider files, such as the vault AWS credentials file, go where the provider is configured to put them
+	if er := secrets.WriteFiles(-1, -1); er != nil {
+		return nil, er
+	}
+	return secrets, failed
+}
+
+// get returns the value of the secret named as list names it, normalized and upcased as configured, or as the
+// provider named it
+func get(secrets *environ.Environ, key string) (string, bool) {
+	if v, ok := secrets.Map()[key]; ok {
+		return v, true
+	}
+	return secrets.Load(key)
+}
+
+// list writes the name of every secret with the provider it came from to w
+func list(w io.Writer, secrets *environ.Environ) {
+	sources := secrets.Sources()
+	for _, k := range sortedKeys(secrets.Map()) {
+		fmt.Fprintf(w, "%s\t%s\n", k, sources[k])
+	}
+}
+
+// formats returns the available output formats. The flags list them before the init func registering the k8s formats
+// has run, so those are added until it has.
+func formats() []string {
+	names := environ.Marshallers()
+	for format := range k8sKinds {
+		if _, er := environ.LookupFormat(format); er != nil {
+			names = append(names, format)
+		}
+	}
+	sort.Strings(names)
+	return append(names, formatDir, formatBundle)
+}
+
+// stdoutPath is the output file name which writes to stdout
+const stdoutPath = "-"
+
+// stdoutArgs moves a - argument behind a --, as kingpin otherwise takes it for a flag
+func stdoutArgs(args []string) []string {
+	out := make([]string, 0, len(args)+1)
+	stdout := false
+	for _, arg := range args {
+		switch arg {
+		case "--":
+			return args
+		case stdoutPath:
+			stdout = true
+		default:
+			out = append(out, arg)
+		}
+	}
+	if stdout {
+		out = append(out, "--", stdoutPath)
+	}
+	return out
+}
+
+// detectFormat returns the format registered for the extension of the file, or json if there is none
+func detectFormat(path string) string {
+	if filepath.Ext(path) == "."+formatBundle {
+		return formatBundle
+	}
+	if name, ok := environ.FormatForFile(path); ok {
+		return name
+	}
+	return "json"
+}
+
+// loadOutputs returns the outputs from the manifest, or the single output file given on the commandline
+func loadOutputs(defaults *output) ([]*output, error) {
+	switch {
+	case *manifestFile != "" && *filename != "":
+		return nil, fmt.Errorf("give either an output file or --manifest, not both")
+	case *manifestFile != "":
+		return loadManifest(*manifestFile, defaults, *force)
+	case *filename == "":
+		return nil, fmt.Errorf("an output file or --manifest is required")
+	}
 
-	if er := secrets.Write(file); er != nil {
-		log.Infof("Failed to write secrets to file. file=%s err=%v", *filename, er)
+	if er := defaults.init(defaults, *force); er != nil {
+		return nil, er
 	}
+	return []*output{defaults}, nil
 }
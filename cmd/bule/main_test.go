@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+)
+
+func TestGetMatchesList(t *testing.T) {
+	secrets := environ.New()
+	secrets.Merge(map[string]string{"db_pass": "lower", "api-key": "dashed"})
+
+	var buf bytes.Buffer
+	list(&buf, secrets)
+	assert.Equal(t, "API_KEY\t\nDB_PASS\t\n", buf.String())
+
+	for key, want := range map[string]string{"DB_PASS": "lower", "API_KEY": "dashed"} {
+		v, ok := get(secrets, key)
+		assert.True(t, ok, key)
+		assert.Equal(t, want, v, key)
+	}
+	v, ok := get(secrets, "db_pass")
+	assert.True(t, ok, "names are looked up as the provider named them too")
+	assert.Equal(t, "lower", v)
+
+	secrets = environ.New()
+	secrets.UpcaseKeys = false
+	secrets.Merge(map[string]string{"db_pass": "lower"})
+	v, ok = get(secrets, "db_pass")
+	assert.True(t, ok)
+	assert.Equal(t, "lower", v)
+}
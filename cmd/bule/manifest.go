@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+// manifest declares many output files rendered from a single run of the providers
+type manifest struct {
+	Outputs []*output `yaml:"outputs"`
+}
+
+// output is a single file written by bule, either marshalled in a format or rendered from a template. Empty fields
+// take the value of the matching flag.
+type output struct {
+	Path     string            `yaml:"path"`
+	Format   string            `yaml:"format"`
+	Template string            `yaml:"template"`
+	Keys     []string          `yaml:"keys"`
+	Rename   map[string]string `yaml:"rename"`
+	Merge    bool              `yaml:"merge"`
+	SetPaths map[string]string `yaml:"set_paths"`
+	Mode     string            `yaml:"mode"`
+	Owner    string            `yaml:"owner"`
+	Group    string            `yaml:"group"`
+
+	opts *fileOpts
+	// encode replaces the registered encoder of the format, for formats such as k8s-secret whose output depends on
+	// the output file
+	encode environ.Encoder
+	// sealedFrom is the manifest last sealed with --k8s-seal, and sealed what the seal command made of it
+	sealedFrom, sealed []byte
+	// renew is set when a bundle is rewritten only to renew its timestamp, as its secrets are unchanged
+	renew bool
+}
+
+// loadManifest reads the outputs from the manifest file, filling in defaults from the flags
+func loadManifest(path string, defaults *output, force bool) ([]*output, error) {
+	data, er := ioutil.ReadFile(path)
+	if er != nil {
+		return nil, er
+	}
+
+	var m manifest
+	if er := yaml.UnmarshalStrict(data, &m); er != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, er)
+	}
+	if len(m.Outputs) == 0 {
+		return nil, fmt.Errorf("no outputs found in %s", path)
+	}
+
+	seen := make(map[string]bool, len(m.Outputs))
+	for i, o := range m.Outputs {
+		if o.Path == "" {
+			return nil, fmt.Errorf("output %d in %s needs a path", i, path)
+		}
+		// each output replaces the whole file, so a second output of the same path would silently win
+		clean := filepath.Clean(o.Path)
+		if seen[clean] {
+			return nil, fmt.Errorf("output %s is listed more than once in %s", o.Path, path)
+		}
+		seen[clean] = true
+		if er := o.init(defaults, force); er != nil {
+			return nil, fmt.Errorf("output %s: %v", o.Path, er)
+		}
+	}
+	return m.Outputs, nil
+}
+
+// init fills empty fields from the defaults and parses the file options
+func (o *output) init(defaults *output, force bool) error {
+	if o.Format == "" {
+		o.Format = defaults.Format
+	}
+	if o.Template == "" {
+		o.Template = defaults.Template
+	}
+	if o.Format == "" && o.Template == "" {
+		o.Format = detectFormat(o.Path)
+	}
+	if o.Path == stdoutPath && (o.Format == formatDir || o.Merge || len(o.SetPaths) > 0) {
+		return fmt.Errorf("writing to stdout is not supported with the dir format or merging")
+	}
+	if !o.Merge {
+		o.Merge = defaults.Merge
+	}
+	if o.SetPaths == nil {
+		o.SetPaths = defaults.SetPaths
+	}
+	if (o.Merge || len(o.SetPaths) > 0) && (o.Template != "" || o.Format == formatDir) {
+		return fmt.Errorf("merging is not supported with a template or the dir format")
+	}
+	_, k8s := k8sKinds[o.Format]
+	if (o.Format == formatBundle || k8s) && (o.Template != "" || o.Merge || len(o.SetPaths) > 0) {
+		return fmt.Errorf("the %s format does not support templates or merging", o.Format)
+	}
+	if k8s {
+		name, er := k8sName(o.Path)
+		if er != nil {
+			return er
+		}
+		o.encode = k8sEncoder(o.Format, name)
+	}
+	if o.Format == formatBundle && *bundleKey == "" {
+		return fmt.Errorf("--bundle-key is required for the bundle format")
+	}
+	if o.Mode == "" {
+		o.Mode = defaults.Mode
+	}
+	if o.Owner == "" {
+		o.Owner = defaults.Owner
+	}
+	if o.Group == "" {
+		o.Group = defaults.Group
+	}
+
+	opts, er := newFileOpts(o.Mode, o.Owner, o.Group, force)
+	if er != nil {
+		return er
+	}
+	o.opts = opts
+	return nil
+}
+
+// selected returns the secrets the output includes: those matching its keys, and those it renames under their new
+// names. An output without keys or renames includes every secret.
+func (o *output) selected(secrets map[string]string) (map[string]string, error) {
+	if len(o.Keys) == 0 && len(o.Rename) == 0 {
+		return secrets, nil
+	}
+
+	m := make(map[string]string)
+	for k, v := range secrets {
+		if environ.MatchAny(o.Keys, k) {
+			m[k] = v
+		}
+	}
+	for from, to := range o.Rename {
+		v, ok := secrets[from]
+		if !ok {
+			return nil, fmt.Errorf("secret %s to rename to %s not found", from, to)
+		}
+		delete(m, from)
+		m[to] = v
+	}
+	return m, nil
+}
+
+// render returns the content of the output file
+func (o *output) render(secrets map[string]string) ([]byte, error) {
+	m, er := o.selected(secrets)
+	if er != nil {
+		return nil, er
+	}
+	if o.Merge || len(o.SetPaths) > 0 {
+		return o.merge(secrets, m)
+	}
+	if o.Format == formatBundle {
+		return sealBundle(m)
+	}
+	if o.encode != nil {
+		buf := new(bytes.Buffer)
+		if er := o.encode(buf, m); er != nil {
+			return nil, er
+		}
+		return buf.Bytes(), nil
+	}
+	if o.Template == "" {
+		return environ.Marshal(o.Format, m)
+	}
+
+	text, er := ioutil.ReadFile(o.Template)
+	if er != nil {
+		return nil, er
+	}
+	out, er := environ.RenderString(string(text), m, *templateStrict)
+	if er != nil {
+		return nil, fmt.Errorf("failed to render template %s: %v", o.Template, er)
+	}
+	return []byte(out), nil
+}
+
+// merge returns the existing file with the selected secrets set at the top level when merging, and the secrets of
+// SetPaths set at their paths
+func (o *output) merge(secrets, selected map[string]string) ([]byte, error) {
+	data, er := ioutil.ReadFile(o.Path)
+	if er != nil && !os.IsNotExist(er) {
+		return nil, er
+	}
+	doc, er := loadDocument(o.Format, data)
+	if er != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", o.Path, er)
+	}
+
+	if o.Merge {
+		for _, k := range sortedKeys(selected) {
+			if er := doc.set([]string{k}, selected[k]); er != nil {
+				return nil, fmt.Errorf("failed to set %s: %v", k, er)
+			}
+		}
+	}
+	for _, k := range sortedKeys(o.SetPaths) {
+		v, ok := secrets[k]
+		if !ok {
+			return nil, fmt.Errorf("secret %s to set at %s not found", k, o.SetPaths[k])
+		}
+		path, er := parsePath(o.SetPaths[k])
+		if er != nil {
+			return nil, er
+		}
+		if er := doc.set(path, v); er != nil {
+			return nil, fmt.Errorf("failed to set %s at %s: %v", k, o.SetPaths[k], er)
+		}
+	}
+	return doc.bytes()
+}
+
+// sortedKeys returns the sorted keys of the map
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// stage writes the new content of the output next to it, unless it is unchanged. Returns funcs which put it in place
+// or throw it away, or nil funcs if unchanged.
+func (o *output) stage(secrets map[string]string) (commit func() error, abort func(), er error) {
+	if o.Format == formatDir && o.Template == "" {
+		m, er := o.selected(secrets)
+		if er != nil {
+			return nil, nil, er
+		}
+		if current := readDir(o.Path); current != nil && reflect.DeepEqual(current, m) {
+			log.Debugf("Output unchanged. dir=%s", o.Path)
+			return nil, nil, applyDir(o.Path, o.opts)
+		}
+
+		snapshot, er := stageDir(o.Path, m, o.opts)
+		if er != nil {
+			return nil, nil, er
+		}
+		return func() error { return commitDir(o.Path, snapshot) }, func() { os.RemoveAll(snapshot) }, nil
+	}
+
+	if o.Format == formatBundle {
+		m, er := o.selected(secrets)
+		if er != nil {
+			return nil, nil, er
+		}
+		o.renew = sameBundle(o.Path, m)
+	}
+
+	b, er := o.render(secrets)
+	if er != nil {
+		return nil, nil, er
+	}
+	if o.seals() {
+		return o.stageSealed(b)
+	}
+	return o.stageBytes(b)
+}
+
+// stageBytes stages b as the new content of the output, unless it is unchanged
+func (o *output) stageBytes(b []byte) (commit func() error, abort func(), er error) {
+	if o.Path == stdoutPath {
+		return func() error {
+			_, er := os.Stdout.Write(b)
+			return er
+		}, func() {}, nil
+	}
+	if current, er := ioutil.ReadFile(o.Path); er == nil && bytes.Equal(current, b) {
+		log.Debugf("Output unchanged. file=%s", o.Path)
+		if er := o.opts.apply(o.Path); er != nil {
+			return nil, nil, fmt.Errorf("failed to set permissions: %v", er)
+		}
+		return nil, nil, nil
+	}
+
+	tmp, er := stageFile(o.Path, b, o.opts)
+	if er != nil {
+		return nil, nil, er
+	}
+	return func() error { return commitFile(tmp, o.Path) }, func() { os.Remove(tmp) }, nil
+}
+
+// seals is true if the output is a manifest piped through --k8s-seal
+func (o *output) seals() bool {
+	_, k8s := k8sKinds[o.Format]
+	return k8s && *k8sSeal != ""
+}
+
+// stageSealed stages the manifest sealed with --k8s-seal. Sealing is not deterministic, so the output is unchanged
+// when the manifest is the one last sealed and the file still holds what the seal command made of it, as the
+// secrets of a bundle are compared rather than its bytes.
+func (o *output) stageSealed(manifest []byte) (func() error, func(), error) {
+	if o.Path != stdoutPath && o.sealed != nil && bytes.Equal(manifest, o.sealedFrom) {
+		if current, er := ioutil.ReadFile(o.Path); er == nil && bytes.Equal(current, o.sealed) {
+			log.Debugf("Output unchanged. file=%s", o.Path)
+			if er := o.opts.apply(o.Path); er != nil {
+				return nil, nil, fmt.Errorf("failed to set permissions: %v", er)
+			}
+			return nil, nil, nil
+		}
+	}
+
+	b, er := seal(manifest)
+	if er != nil {
+		return nil, nil, er
+	}
+	write, abort, er := o.stageBytes(b)
+	if er != nil || write == nil {
+		return write, abort, er
+	}
+	return func() error {
+		if er := write(); er != nil {
+			return er
+		}
+		o.sealedFrom, o.sealed = manifest, b
+		return nil
+	}, abort, nil
+}
+
+// writeOutputs stages every changed output before putting any of them in place, so a failure to render or write one
+// leaves every output as it was. Each staged output is then put in place with a rename, one after another: should a
+// rename fail, the outputs before it are already written and the rest are left as they were. Outputs whose content has
+// not changed are left alone. Returns the paths of the outputs written. Bundles are always rewritten, renewing their
+// timestamp for the max age check of the bundle provider, but only count as changed when their secrets did.
+func writeOutputs(outputs []*output, secrets map[string]string) ([]string, error) {
+	var (
+		changed []*output
+		commits []func() error
+		aborts  []func()
+	)
+	for _, o := range outputs {
+		commit, abort, er := o.stage(secrets)
+		if er != nil {
+			for _, abort := range aborts {
+				abort()
+			}
+			return nil, fmt.Errorf("failed to write %s: %v", o.Path, er)
+		}
+		if commit != nil {
+			changed = append(changed, o)
+			commits = append(commits, commit)
+			aborts = append(aborts, abort)
+		}
+	}
+
+	paths := make([]string, 0, len(changed))
+	for i, o := range changed {
+		log.Debugf("Writing secrets to file. file=%s fmt=%s", o.Path, o.Format)
+		if er := commits[i](); er != nil {
+			for _, abort := range aborts[i+1:] {
+				abort()
+			}
+			return paths, fmt.Errorf("failed to write %s: %v", o.Path, er)
+		}
+		if !o.renew {
+			paths = append(paths, o.Path)
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadManifest(t *testing.T) {
+	dir, er := ioutil.TempDir("", "bule-test-")
+	require.NoError(t, er)
+	defer os.RemoveAll(dir)
+
+	load := func(doc string, defaults *output) ([]*output, error) {
+		path := filepath.Join(dir, "manifest.yaml")
+		require.NoError(t, ioutil.WriteFile(path, []byte(doc), 0600))
+		return loadManifest(path, defaults, false)
+	}
+	defaults := &output{Mode: "0600"}
+
+	outputs, er := load(`
+outputs:
+  - path: app.env
+  - path: config/app.toml
+    keys: [DB_*]
+  - path: other.json
+    format: yaml
+    mode: "0400"
+`, &output{Mode: "0640", Owner: "0"})
+	require.NoError(t, er)
+	require.Len(t, outputs, 3)
+	assert.Equal(t, "dotenv", outputs[0].Format, "format of the file extension")
+	assert.Equal(t, "toml", outputs[1].Format)
+	assert.Equal(t, "yaml", outputs[2].Format)
+	assert.Equal(t, os.FileMode(0640), outputs[0].opts.mode, "mode of the flag")
+	assert.Equal(t, 0, outputs[0].opts.uid, "owner of the flag")
+	assert.Equal(t, os.FileMode(0400), outputs[2].opts.mode, "an output overrides the flag")
+
+	outputs, er = load("outputs:\n  - path: app.env\n", &output{Format: "json", Mode: "0600"})
+	require.NoError(t, er)
+	assert.Equal(t, "json", outputs[0].Format, "--format wins over the extension")
+
+	tests := map[string]string{
+		"outputs:\n  - path: a.json\n  - path: ./a.json\n": "more than once",
+		"outputs:\n  - format: json\n":                     "needs a path",
+		"outputs: []\n":                                    "no outputs",
+		"outputs:\n  - path: a.json\n    colour: red\n":    "failed to parse",
+		"outputs:\n  - path: a.json\n    mode: rw\n":       "invalid mode",
+	}
+	for doc, want := range tests {
+		_, er := load(doc, defaults)
+		if assert.Errorf(t, er, doc) {
+			assert.Containsf(t, er.Error(), want, doc)
+		}
+	}
+}
+
+func TestOutputSelected(t *testing.T) {
+	secrets := map[string]string{"DB_USER": "app", "DB_PASS": "hunter2", "API_KEY": "abc"}
+
+	m, er := (&output{}).selected(secrets)
+	require.NoError(t, er)
+	assert.Equal(t, secrets, m, "every secret without keys or renames")
+
+	m, er = (&output{Keys: []string{"DB_*"}}).selected(secrets)
+	require.NoError(t, er)
+	assert.Equal(t, map[string]string{"DB_USER": "app", "DB_PASS": "hunter2"}, m)
+
+	m, er = (&output{Keys: []string{"DB_USER"}, Rename: map[string]string{"API_KEY": "TOKEN"}}).selected(secrets)
+	require.NoError(t, er)
+	assert.Equal(t, map[string]string{"DB_USER": "app", "TOKEN": "abc"}, m)
+
+	_, er = (&output{Rename: map[string]string{"MISSING": "X"}}).selected(secrets)
+	if assert.Error(t, er) {
+		assert.Contains(t, er.Error(), "MISSING")
+	}
+}
+
+func TestWriteOutputsFailure(t *testing.T) {
+	dir, er := ioutil.TempDir("", "bule-test-")
+	require.NoError(t, er)
+	defer os.RemoveAll(dir)
+
+	first := &output{Path: filepath.Join(dir, "first.env")}
+	second := &output{Path: filepath.Join(dir, "second.json")}
+	third := &output{Path: filepath.Join(dir, "third.json"), Rename: map[string]string{"MISSING": "X"}}
+	for _, o := range []*output{first, second, third} {
+		require.NoError(t, o.init(&output{Mode: "0600"}, false))
+	}
+	require.NoError(t, ioutil.WriteFile(first.Path, []byte("A=\"old\"\n"), 0600))
+
+	written, er := writeOutputs([]*output{first, second, third}, map[string]string{"A": "new"})
+	assert.Error(t, er)
+	assert.Empty(t, written)
+	data, er := ioutil.ReadFile(first.Path)
+	require.NoError(t, er)
+	assert.Equal(t, "A=\"old\"\n", string(data), "outputs staged before the failure are not written")
+	assertOnly(t, dir, "first.env")
+
+	written, er = writeOutputs([]*output{first, second}, map[string]string{"A": "new"})
+	require.NoError(t, er)
+	assert.Equal(t, []string{first.Path, second.Path}, written)
+
+	written, er = writeOutputs([]*output{first, second}, map[string]string{"A": "new"})
+	require.NoError(t, er)
+	assert.Empty(t, written, "unchanged outputs are left alone")
+}
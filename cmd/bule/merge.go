@@ -0,0 +1,595 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+)
+
+// document is an existing file secrets are merged into, keeping everything else in it as it was
+type document interface {
+	// set places value at path, replacing whatever was there
+	set(path []string, value string) error
+	bytes() ([]byte, error)
+}
+
+// loadDocument parses data in the named format. Empty data is an empty document.
+func loadDocument(format string, data []byte) (document, error) {
+	switch strings.ToLower(format) {
+	case "json":
+		return newJSONDocument(data)
+	case "yaml", "yml":
+		return &yamlDocument{lines: splitLines(data)}, nil
+	case "toml":
+		return &tomlDocument{lines: splitLines(data)}, nil
+	case "env", "dotenv":
+		return &dotenvDocument{lines: splitLines(data)}, nil
+	}
+	return nil, fmt.Errorf("merging into %s files is not supported", format)
+}
+
+// parsePath splits a JSON pointer (/a/b) or a dotted path (a.b) into its keys
+func parsePath(p string) ([]string, error) {
+	var keys []string
+	if strings.HasPrefix(p, "/") {
+		for _, key := range strings.Split(p[1:], "/") {
+			keys = append(keys, strings.Replace(strings.Replace(key, "~1", "/", -1), "~0", "~", -1))
+		}
+	} else {
+		keys = strings.Split(p, ".")
+	}
+
+	for _, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("invalid path %q", p)
+		}
+	}
+	return keys, nil
+}
+
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func joinLines(lines []string) []byte {
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func insertLines(lines []string, at int, insert ...string) []string {
+	out := make([]string, 0, len(lines)+len(insert))
+	out = append(out, lines[:at]...)
+	out = append(out, insert...)
+	return append(out, lines[at:]...)
+}
+
+// indentOf returns the number of leading spaces of the line
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// isContent returns false for blank lines and comments
+func isContent(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed != "" && !strings.HasPrefix(trimmed, "#")
+}
+
+// trailingComment returns the comment ending a YAML, TOML or dotenv value preceded by a space, or "" if there is none. A #
+// inside a quoted value does not start a comment.
+func trailingComment(value string) string {
+	if strings.HasPrefix(value, "#") {
+		return " " + value
+	}
+
+	i := 0
+	if q := value; q != "" && (q[0] == '"' || q[0] == '\'') {
+		for i = 1; i < len(q); i++ {
+			if q[0] == '"' && q[i] == '\\' {
+				i++
+			} else if q[i] == q[0] {
+				// '' is a quote inside a single quoted YAML string
+				if q[0] == '\'' && i+1 < len(q) && q[i+1] == '\'' {
+					i++
+					continue
+				}
+				break
+			}
+		}
+	}
+
+	for j := i; j < len(value); j++ {
+		if value[j] == '#' && j > 0 && (value[j-1] == ' ' || value[j-1] == '\t') {
+			return " " + value[j:]
+		}
+	}
+	return ""
+}
+
+// dotenvDocument edits KEY=value lines in place and appends new keys
+type dotenvDocument struct {
+	lines []string
+}
+
+var dotenvLine = regexp.MustCompile(`^\s*(export\s+)?([A-Za-z0-9_.]+)\s*=`)
+
+func (d *dotenvDocument) set(path []string, value string) error {
+	if len(path) != 1 {
+		return fmt.Errorf("dotenv files have no nested paths")
+	}
+
+	b, er := environ.Marshal("dotenv", map[string]string{path[0]: value})
+	if er != nil {
+		return er
+	}
+	entry := strings.TrimSuffix(string(b), "\n")
+
+	for i, line := range d.lines {
+		if m := dotenvLine.FindStringSubmatch(line); m != nil && m[2] == path[0] {
+			d.lines[i] = m[1] + entry + trailingComment(strings.TrimSpace(line[len(m[0]):]))
+			return nil
+		}
+	}
+	d.lines = append(d.lines, entry)
+	return nil
+}
+
+func (d *dotenvDocument) bytes() ([]byte, error) {
+	return joinLines(d.lines), nil
+}
+
+// tomlDocument edits key = value lines in their table in place, adding keys and tables which do not exist
+type tomlDocument struct {
+	lines []string
+}
+
+var (
+	tomlTable = regexp.MustCompile(`^\s*\[\s*([^\[\]]+?)\s*\]\s*(#.*)?$`)
+	tomlArray = regexp.MustCompile(`^\s*\[\[`)
+	tomlKey   = regexp.MustCompile(`^\s*("[^"]*"|'[^']*'|[A-Za-z0-9_-]+)\s*=`)
+	tomlBare  = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+func tomlName(keys []string) string {
+	quoted := make([]string, len(keys))
+	for i, key := range keys {
+		if tomlBare.MatchString(key) {
+			quoted[i] = key
+		} else {
+			quoted[i] = strconv.Quote(key)
+		}
+	}
+	return strings.Join(quoted, ".")
+}
+
+// tomlTableName normalizes the name in a table header so it can be compared
+func tomlTableName(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if unquoted, er := strconv.Unquote(part); er == nil {
+			part = unquoted
+		}
+		parts[i] = part
+	}
+	return tomlName(parts)
+}
+
+func (d *tomlDocument) set(path []string, value string) error {
+	table, key := tomlName(path[:len(path)-1]), path[len(path)-1]
+	b, er := environ.Marshal("toml", map[string]string{key: value})
+	if er != nil {
+		return er
+	}
+	entry := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+
+	current, found, last := "", table == "", -1
+	for i := 0; i < len(d.lines); i++ {
+		line := d.lines[i]
+		if tomlArray.MatchString(line) {
+			current = "[["
+			continue
+		}
+		if m := tomlTable.FindStringSubmatch(line); m != nil {
+			if found && current == table {
+				break
+			}
+			current = tomlTableName(m[1])
+			if current == table {
+				found, last = true, i
+			}
+			continue
+		}
+		if current != table {
+			continue
+		}
+		if isContent(line) {
+			last = i
+		}
+
+		m := tomlKey.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		name := m[1]
+		if unquoted, er := strconv.Unquote(name); er == nil {
+			name = unquoted
+		}
+		name = strings.Trim(name, "'")
+		if name != key {
+			continue
+		}
+
+		end := i + 1
+		rest := strings.TrimSpace(line[len(m[0]):])
+		if strings.HasPrefix(rest, `"""`) || strings.HasPrefix(rest, `'''`) {
+			delim := rest[:3]
+			if n := strings.Index(rest[3:], delim); n >= 0 {
+				rest = strings.TrimSpace(rest[n+6:])
+			} else {
+				for end < len(d.lines) && !strings.Contains(d.lines[end], delim) {
+					end++
+				}
+				rest = ""
+				if end < len(d.lines) {
+					closing := d.lines[end]
+					rest = strings.TrimSpace(closing[strings.LastIndex(closing, delim)+3:])
+				}
+				end++
+			}
+		}
+		if end > len(d.lines) {
+			end = len(d.lines)
+		}
+		entry[len(entry)-1] += trailingComment(rest)
+		d.lines = append(d.lines[:i], append(entry, d.lines[end:]...)...)
+		return nil
+	}
+
+	switch {
+	case found:
+		d.lines = insertLines(d.lines, last+1, entry...)
+	default:
+		if len(d.lines) > 0 {
+			d.lines = append(d.lines, "")
+		}
+		d.lines = append(d.lines, "["+table+"]")
+		d.lines = append(d.lines, entry...)
+	}
+	return nil
+}
+
+func (d *tomlDocument) bytes() ([]byte, error) {
+	return joinLines(d.lines), nil
+}
+
+// yamlDocument edits block mapping entries in place, adding entries which do not exist. Paths through sequences are
+// not supported.
+type yamlDocument struct {
+	lines []string
+}
+
+var yamlKey = regexp.MustCompile(`^(\s*)("[^"]*"|'[^']*'|[^\s#'"\-{\[][^:#]*?|-[^\s:#][^:#]*?)\s*:(\s|$)`)
+
+// yamlEntry is a mapping entry found in the document
+type yamlEntry struct {
+	line, indent, end int
+	inline            bool
+	comment           string
+}
+
+// find returns the entry at the deepest existing prefix of path and the length of that prefix
+func (d *yamlDocument) find(path []string) (*yamlEntry, int) {
+	type level struct {
+		indent int
+		key    string
+	}
+
+	var (
+		stack  []level
+		best   *yamlEntry
+		depth  int
+		scalar = -1
+	)
+	for i, line := range d.lines {
+		if !isContent(line) {
+			continue
+		}
+		indent := indentOf(line)
+		// lines of a block scalar are not entries
+		if scalar >= 0 && indent > scalar {
+			continue
+		}
+		scalar = -1
+
+		m := yamlKey.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		key := m[2]
+		if unquoted, er := strconv.Unquote(key); er == nil {
+			key = unquoted
+		}
+		key = strings.Trim(key, "'")
+		stack = append(stack, level{indent, key})
+
+		rest := strings.TrimSpace(line[len(m[0]):])
+		if strings.HasPrefix(rest, "|") || strings.HasPrefix(rest, ">") {
+			scalar = indent
+		}
+
+		if len(stack) > len(path) || len(stack) <= depth {
+			continue
+		}
+		match := true
+		for j, l := range stack {
+			if l.key != path[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			inline := rest != "" && !strings.HasPrefix(rest, "#") && !strings.HasPrefix(rest, "&")
+			best, depth = &yamlEntry{line: i, indent: indent, end: d.blockEnd(i, indent), inline: inline, comment: trailingComment(rest)}, len(stack)
+		}
+	}
+	return best, depth
+}
+
+// blockEnd returns the index after the last line belonging to the entry at line i: the lines indented deeper than
+// it, and the items of a sequence at its own indent
+func (d *yamlDocument) blockEnd(i, indent int) int {
+	end := i + 1
+	for j := i + 1; j < len(d.lines); j++ {
+		if !isContent(d.lines[j]) {
+			continue
+		}
+		n := indentOf(d.lines[j])
+		if n < indent || n == indent && !strings.HasPrefix(strings.TrimSpace(d.lines[j]), "-") {
+			break
+		}
+		end = j + 1
+	}
+	return end
+}
+
+// yamlSnippet marshals value nested under keys, indented by indent
+func yamlSnippet(keys []string, value string, indent int) ([]string, error) {
+	var v interface{} = value
+	for i := len(keys) - 1; i >= 0; i-- {
+		v = yaml.MapSlice{{Key: keys[i], Value: v}}
+	}
+	b, er := yaml.Marshal(v)
+	if er != nil {
+		return nil, er
+	}
+
+	lines := splitLines(b)
+	pad := strings.Repeat(" ", indent)
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = pad + line
+		}
+	}
+	return lines, nil
+}
+
+func (d *yamlDocument) set(path []string, value string) error {
+	entry, depth := d.find(path)
+
+	if depth == len(path) {
+		snippet, er := yamlSnippet(path[len(path)-1:], value, entry.indent)
+		if er != nil {
+			return er
+		}
+		if entry.comment != "" {
+			// a comment ends a plain or quoted scalar, so one folded over several lines gets it on a line of its own
+			if header := strings.TrimRight(snippet[0], "-+0123456789"); len(snippet) == 1 || strings.HasSuffix(header, "|") || strings.HasSuffix(header, ">") {
+				snippet[0] += entry.comment
+			} else {
+				snippet = append([]string{strings.Repeat(" ", entry.indent) + strings.TrimSpace(entry.comment)}, snippet...)
+			}
+		}
+		d.lines = append(d.lines[:entry.line], append(snippet, d.lines[entry.end:]...)...)
+		return nil
+	}
+
+	if entry == nil {
+		snippet, er := yamlSnippet(path, value, 0)
+		if er != nil {
+			return er
+		}
+		d.lines = append(d.lines, snippet...)
+		return nil
+	}
+
+	if entry.inline {
+		return fmt.Errorf("%s is not a mapping", strings.Join(path[:depth], "."))
+	}
+	indent := entry.indent + 2
+	for j := entry.line + 1; j < entry.end; j++ {
+		if isContent(d.lines[j]) {
+			indent = indentOf(d.lines[j])
+			break
+		}
+	}
+	snippet, er := yamlSnippet(path[depth:], value, indent)
+	if er != nil {
+		return er
+	}
+	d.lines = insertLines(d.lines, entry.end, snippet...)
+	return nil
+}
+
+func (d *yamlDocument) bytes() ([]byte, error) {
+	return joinLines(d.lines), nil
+}
+
+// jsonDocument is a JSON document with the order of object keys kept
+type jsonDocument struct {
+	root interface{}
+}
+
+// jsonObject is a JSON object with the order of its keys kept
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{values: make(map[string]interface{})}
+}
+
+func (o *jsonObject) set(key string, v interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+func newJSONDocument(data []byte) (*jsonDocument, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return &jsonDocument{root: newJSONObject()}, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	root, er := decodeJSON(dec)
+	if er != nil {
+		return nil, er
+	}
+	return &jsonDocument{root: root}, nil
+}
+
+func decodeJSON(dec *json.Decoder) (interface{}, error) {
+	tok, er := dec.Token()
+	if er != nil {
+		return nil, er
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		obj := newJSONObject()
+		for dec.More() {
+			key, er := dec.Token()
+			if er != nil {
+				return nil, er
+			}
+			v, er := decodeJSON(dec)
+			if er != nil {
+				return nil, er
+			}
+			obj.set(key.(string), v)
+		}
+		_, er := dec.Token()
+		return obj, er
+	case json.Delim('['):
+		arr := make([]interface{}, 0)
+		for dec.More() {
+			v, er := decodeJSON(dec)
+			if er != nil {
+				return nil, er
+			}
+			arr = append(arr, v)
+		}
+		_, er := dec.Token()
+		return arr, er
+	}
+	return tok, nil
+}
+
+func (d *jsonDocument) set(path []string, value string) error {
+	obj, ok := d.root.(*jsonObject)
+	if !ok {
+		return fmt.Errorf("document is not an object")
+	}
+
+	for i, key := range path[:len(path)-1] {
+		next, ok := obj.values[key]
+		if !ok {
+			child := newJSONObject()
+			obj.set(key, child)
+			obj = child
+			continue
+		}
+		if obj, ok = next.(*jsonObject); !ok {
+			return fmt.Errorf("/%s is not an object", strings.Join(path[:i+1], "/"))
+		}
+	}
+	obj.set(path[len(path)-1], value)
+	return nil
+}
+
+func (d *jsonDocument) bytes() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if er := encodeJSON(buf, d.root, ""); er != nil {
+		return nil, er
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+func encodeJSON(w io.Writer, v interface{}, indent string) error {
+	inner := indent + "  "
+	switch t := v.(type) {
+	case *jsonObject:
+		if len(t.keys) == 0 {
+			_, er := io.WriteString(w, "{}")
+			return er
+		}
+		io.WriteString(w, "{\n")
+		for i, key := range t.keys {
+			k, _ := json.Marshal(key)
+			fmt.Fprintf(w, "%s%s: ", inner, k)
+			if er := encodeJSON(w, t.values[key], inner); er != nil {
+				return er
+			}
+			if i < len(t.keys)-1 {
+				io.WriteString(w, ",")
+			}
+			io.WriteString(w, "\n")
+		}
+		_, er := io.WriteString(w, indent+"}")
+		return er
+	case []interface{}:
+		if len(t) == 0 {
+			_, er := io.WriteString(w, "[]")
+			return er
+		}
+		io.WriteString(w, "[\n")
+		for i, item := range t {
+			io.WriteString(w, inner)
+			if er := encodeJSON(w, item, inner); er != nil {
+				return er
+			}
+			if i < len(t)-1 {
+				io.WriteString(w, ",")
+			}
+			io.WriteString(w, "\n")
+		}
+		_, er := io.WriteString(w, indent+"]")
+		return er
+	}
+
+	b, er := json.Marshal(v)
+	if er != nil {
+		return er
+	}
+	_, er = w.Write(b)
+	return er
+}
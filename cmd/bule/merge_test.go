@@ -0,0 +1,174 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeDocument(t *testing.T) {
+	type set struct {
+		path  string
+		value string
+	}
+
+	tt := []struct {
+		name   string
+		format string
+		in     string
+		sets   []set
+		out    string
+		err    bool
+	}{
+		{
+			name:   "yaml keeps comments and key order",
+			format: "yaml",
+			in:     "# secrets\nzeta: 1 # last\nalpha: 2\n\n# trailer\n",
+			sets:   []set{{"zeta", "z"}, {"beta", "b"}},
+			out:    "# secrets\nzeta: z # last\nalpha: 2\n\n# trailer\nbeta: b\n",
+		},
+		{
+			name:   "yaml comment after a quoted value with a #",
+			format: "yaml",
+			in:     "pass: \"a # b\"   # rotated monthly\n",
+			sets:   []set{{"pass", "new"}},
+			out:    "pass: new # rotated monthly\n",
+		},
+		{
+			name:   "yaml nested paths",
+			format: "yaml",
+			in:     "db:\n    host: db.local  # primary\n    port: 5432\napp: web\n",
+			sets:   []set{{"db.host", "db2"}, {"/db/password", "s3cret"}, {"db.creds.user", "admin"}, {"cache.url", "redis://"}},
+			out:    "db:\n    host: db2 # primary\n    port: 5432\n    password: s3cret\n    creds:\n      user: admin\napp: web\ncache:\n  url: redis://\n",
+		},
+		{
+			name:   "yaml block scalars",
+			format: "yaml",
+			in:     "cert: |\n  -----BEGIN-----\n  key: not an entry\n  -----END-----\nnext: 1\n",
+			sets:   []set{{"key", "top"}, {"cert", "a\nb"}},
+			out:    "cert: |-\n  a\n  b\nnext: 1\nkey: top\n",
+		},
+		{
+			name:   "yaml multi-line value keeps the comment on its header",
+			format: "yaml",
+			in:     "pem: old # from vault\n",
+			sets:   []set{{"pem", "line1\nline2"}},
+			out:    "pem: |- # from vault\n  line1\n  line2\n",
+		},
+		{
+			name:   "yaml folded value gets the comment on its own line",
+			format: "yaml",
+			in:     "long: old # note\n",
+			sets:   []set{{"long", "aaaa bbbb cccc dddd eeee ffff gggg hhhh iiii jjjj kkkk llll mmmm nnnn oooo pppp qqqq"}},
+			out:    "# note\nlong: aaaa bbbb cccc dddd eeee ffff gggg hhhh iiii jjjj kkkk llll mmmm nnnn oooo pppp\n  qqqq\n",
+		},
+		{
+			name:   "yaml path through a scalar",
+			format: "yaml",
+			in:     "db: postgres://\n",
+			sets:   []set{{"db.password", "x"}},
+			err:    true,
+		},
+		{
+			name:   "toml tables",
+			format: "toml",
+			in:     "# app\ntitle = \"t\"\n\n[db]\nhost = \"h\" # primary\nport = 5432\n\n[[workers]]\nhost = \"w\"\n\n[\"cache\"]\nurl = \"u\"\n",
+			sets:   []set{{"db.host", "db2"}, {"db.password", "s3cret"}, {"title", "new"}, {"cache.url", "redis://"}, {"queue.name", "q"}},
+			out:    "# app\ntitle = \"new\"\n\n[db]\nhost = \"db2\" # primary\nport = 5432\npassword = \"s3cret\"\n\n[[workers]]\nhost = \"w\"\n\n[\"cache\"]\nurl = \"redis://\"\n\n[queue]\nname = \"q\"\n",
+		},
+		{
+			name:   "toml multi-line strings",
+			format: "toml",
+			in:     "[tls]\nkey = \"\"\"\nhost = not a key\n\"\"\" # pem\nliteral = '''one line'''\nport = 1\n",
+			sets:   []set{{"tls.key", "a\nb"}, {"tls.literal", "two"}, {"tls.host", "h"}},
+			out:    "[tls]\nkey = \"a\\nb\" # pem\nliteral = \"two\"\nport = 1\nhost = \"h\"\n",
+		},
+		{
+			name:   "dotenv",
+			format: "dotenv",
+			in:     "# local\nexport DB_PASS=old\nAPP=web\n",
+			sets:   []set{{"DB_PASS", "new"}, {"TOKEN", "t"}},
+			out:    "# local\nexport DB_PASS=\"new\"\nAPP=web\nTOKEN=\"t\"\n",
+		},
+		{
+			name:   "dotenv comments",
+			format: "dotenv",
+			in:     "FOO=old # keep\nQUOTED=\"a # b\"\t# rotate\nHASH=a#b\n",
+			sets:   []set{{"FOO", "bar"}, {"QUOTED", "c"}, {"HASH", "d"}},
+			out:    "FOO=\"bar\" # keep\nQUOTED=\"c\" # rotate\nHASH=\"d\"\n",
+		},
+		{
+			name:   "dotenv has no nested paths",
+			format: "dotenv",
+			sets:   []set{{"a.b", "x"}},
+			err:    true,
+		},
+		{
+			name:   "json keeps key order",
+			format: "json",
+			in:     `{"zeta": 1, "db": {"host": "h"}, "list": [1, "two"]}`,
+			sets:   []set{{"/db/password", "s3cret"}, {"alpha", "a"}},
+			out:    "{\n  \"zeta\": 1,\n  \"db\": {\n    \"host\": \"h\",\n    \"password\": \"s3cret\"\n  },\n  \"list\": [\n    1,\n    \"two\"\n  ],\n  \"alpha\": \"a\"\n}\n",
+		},
+		{
+			name:   "json path through a scalar",
+			format: "json",
+			in:     `{"db": "postgres://"}`,
+			sets:   []set{{"/db/password", "x"}},
+			err:    true,
+		},
+	}
+
+	for _, test := range tt {
+		doc, er := loadDocument(test.format, []byte(test.in))
+		require.NoError(t, er, test.name)
+
+		var failed error
+		for _, s := range test.sets {
+			path, er := parsePath(s.path)
+			require.NoError(t, er, test.name)
+			if er := doc.set(path, s.value); er != nil {
+				failed = er
+				break
+			}
+		}
+		if test.err {
+			assert.Error(t, failed, test.name)
+			continue
+		}
+		require.NoError(t, failed, test.name)
+
+		out, er := doc.bytes()
+		require.NoError(t, er, test.name)
+		assert.Equal(t, test.out, string(out), test.name)
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tt := []struct {
+		in   string
+		keys []string
+	}{
+		{"a", []string{"a"}},
+		{"a.b.c", []string{"a", "b", "c"}},
+		{"/a/b", []string{"a", "b"}},
+		{"/a~1b/c~0d", []string{"a/b", "c~d"}},
+		{"a..b", nil},
+		{"/", nil},
+	}
+
+	for _, test := range tt {
+		keys, er := parsePath(test.in)
+		if test.keys == nil {
+			assert.Error(t, er, test.in)
+			continue
+		}
+		assert.Equal(t, test.keys, keys, test.in)
+	}
+}
+
+func TestLoadDocumentUnsupported(t *testing.T) {
+	_, er := loadDocument("ini", nil)
+	assert.Error(t, er)
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"errors"
+)
+
+// finding processes by name is not supported on darwin, use --signal-pidfile instead
+func findProcesses(name string) ([]int, error) {
+	return nil, errors.New("finding processes by name is not supported on darwin")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// commLen is the length names are truncated to in /proc/<pid>/comm
+const commLen = 15
+
+// findProcesses returns the pids of the processes whose name, as in /proc/<pid>/comm, is name
+func findProcesses(name string) ([]int, error) {
+	if len(name) > commLen {
+		name = name[:commLen]
+	}
+
+	paths, er := filepath.Glob("/proc/[0-9]*/comm")
+	if er != nil {
+		return nil, er
+	}
+
+	var pids []int
+	for _, path := range paths {
+		comm, er := ioutil.ReadFile(path)
+		if er != nil || strings.TrimSpace(string(comm)) != name {
+			continue
+		}
+		if pid, er := strconv.Atoi(filepath.Base(filepath.Dir(path))); er == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lumoslabs/vestibule/pkg/environ/providers/vault"
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+// push writes the gathered secrets to the --to destination, merged into its existing keys unless --replace, and
+// writes the changes to w with values masked or hashed
+func push(w io.Writer) error {
+	bits := strings.SplitN(*pushTo, ":", 2)
+	if len(bits) != 2 || bits[0] != vault.Name || bits[1] == "" {
+		return fmt.Errorf("unsupported destination %q, expected vault:PATH", *pushTo)
+	}
+	path := bits[1]
+
+	gathered, er := gather(*providers)
+	if er != nil {
+		return er
+	}
+	secrets := gathered.Map()
+
+	providerEnv.Restore()
+	client, er := vault.NewClient()
+	if er != nil {
+		return er
+	}
+	current, er := client.ReadKV(path)
+	if er != nil {
+		return er
+	}
+	if *pushCAS && !current.V2() {
+		return fmt.Errorf("%s is in a KV v1 mount, which does not support check-and-set, use --no-cas", path)
+	}
+
+	data := make(map[string]interface{}, len(current.Data)+len(secrets))
+	if !*pushReplace {
+		for k, v := range current.Data {
+			data[k] = v
+		}
+	}
+	for k, v := range secrets {
+		data[existingKey(current.Data, secrets, k)] = v
+	}
+	pushed := &vault.KVSecret{Data: data}
+
+	if !changes(w, current.Strings(), pushed.Strings(), *pushValues) {
+		log.Infof("Secret is up to date. path=%s", path)
+		return nil
+	}
+	if *pushDryRun {
+		return nil
+	}
+
+	if er := client.WriteKV(current, data, *pushCAS); er != nil {
+		return er
+	}
+	log.Infof("Pushed secrets. path=%s version=%d", path, current.Version)
+	return nil
+}
+
+// existingKey returns the name of the key in data which k names ignoring case, or k if there is none. Upcasing
+// changes the names of the gathered keys, which must not add a second spelling of a key next to the one in vault.
+// Names which were gathered themselves are left to their own value.
+func existingKey(data map[string]interface{}, secrets map[string]string, k string) string {
+	if _, ok := data[k]; ok {
+		return k
+	}
+	match := ""
+	for name := range data {
+		if _, ok := secrets[name]; !ok && strings.EqualFold(name, k) && (match == "" || name < match) {
+			match = name
+		}
+	}
+	if match == "" {
+		return k
+	}
+	log.Debugf("Pushing to existing key. key=%s existing=%s", k, match)
+	return match
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKV mocks vault with a KV v2 mount at secret/ and a KV v1 mount at kv/
+type fakeKV struct {
+	sync.Mutex
+	data    map[string]map[string]interface{}
+	version map[string]int
+	writes  int
+	// race is written by another client just before the next write lands
+	race map[string]interface{}
+}
+
+func newFakeKV() (*fakeKV, func()) {
+	kv := &fakeKV{data: map[string]map[string]interface{}{}, version: map[string]int{}}
+	ts := httptest.NewServer(kv)
+	os.Setenv("VAULT_ADDR", ts.URL)
+	os.Setenv("VAULT_TOKEN", "test-token")
+	return kv, func() {
+		ts.Close()
+		os.Unsetenv("VAULT_ADDR")
+		os.Unsetenv("VAULT_TOKEN")
+	}
+}
+
+func (kv *fakeKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	kv.Lock()
+	defer kv.Unlock()
+
+	p := strings.TrimPrefix(r.URL.Path, "/v1/")
+	switch {
+	case strings.HasPrefix(p, "sys/internal/ui/mounts/secret/"):
+		fmt.Fprintln(w, `{"data": {"path": "secret/", "type": "kv", "options": {"version": "2"}}}`)
+	case strings.HasPrefix(p, "sys/internal/ui/mounts/kv/"):
+		fmt.Fprintln(w, `{"data": {"path": "kv/", "type": "kv", "options": null}}`)
+	case r.Method == http.MethodGet:
+		data, ok := kv.data[p]
+		if !ok {
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+			return
+		}
+		b, _ := json.Marshal(data)
+		if strings.HasPrefix(p, "kv/") {
+			fmt.Fprintf(w, `{"data": %s}`, b)
+			return
+		}
+		fmt.Fprintf(w, `{"data": {"data": %s, "metadata": {"version": %d}}}`, b, kv.version[p])
+	case strings.HasPrefix(p, "kv/"):
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		kv.data[p] = body
+		kv.writes++
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		if kv.race != nil {
+			kv.data[p], kv.race = kv.race, nil
+			kv.version[p]++
+		}
+		var body struct {
+			Data    map[string]interface{}
+			Options map[string]int
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if cas, ok := body.Options["cas"]; ok && cas != kv.version[p] {
+			http.Error(w, `{"errors":["check-and-set parameter did not match the current version"]}`, http.StatusBadRequest)
+			return
+		}
+		kv.data[p] = body.Data
+		kv.version[p]++
+		kv.writes++
+		fmt.Fprintf(w, `{"data": {"version": %d}}`, kv.version[p])
+	}
+}
+
+func (kv *fakeKV) get(path string) map[string]interface{} {
+	kv.Lock()
+	defer kv.Unlock()
+	return kv.data[path]
+}
+
+func (kv *fakeKV) set(path string, data map[string]interface{}) {
+	kv.Lock()
+	defer kv.Unlock()
+	kv.data[path] = data
+	kv.version[path]++
+}
+
+func TestPush(t *testing.T) {
+	kv, done := newFakeKV()
+	defer done()
+	defer useStub()()
+	defer func(to string, dry, replace, cas bool, values string) {
+		*pushTo, *pushDryRun, *pushReplace, *pushCAS, *pushValues = to, dry, replace, cas, values
+	}(*pushTo, *pushDryRun, *pushReplace, *pushCAS, *pushValues)
+	*pushTo, *pushDryRun, *pushReplace, *pushCAS, *pushValues = "vault:secret/app", false, false, true, valuesMask
+
+	run := func() (string, error) {
+		var buf bytes.Buffer
+		er := push(&buf)
+		return buf.String(), er
+	}
+
+	kv.set("secret/data/app", map[string]interface{}{"db_pass": "old", "KEPT": "kept"})
+	setStub(map[string]string{"DB_PASS": "new", "API_KEY": "abc"}, nil)
+
+	*pushDryRun = true
+	out, er := run()
+	require.NoError(t, er)
+	assert.Equal(t, "+ API_KEY ***\n~ db_pass *** -> ***\n", out)
+	assert.Equal(t, 0, kv.writes, "a dry run writes nothing")
+
+	*pushDryRun = false
+	_, er = run()
+	require.NoError(t, er)
+	assert.Equal(t, map[string]interface{}{"db_pass": "new", "KEPT": "kept", "API_KEY": "abc"}, kv.get("secret/data/app"),
+		"merged into the existing keys, under their existing spelling")
+
+	out, er = run()
+	require.NoError(t, er)
+	assert.Empty(t, out)
+	assert.Equal(t, 1, kv.writes, "an up to date secret is not written")
+
+	*pushReplace = true
+	out, er = run()
+	require.NoError(t, er)
+	assert.Equal(t, "- KEPT ***\n", out)
+	assert.Equal(t, map[string]interface{}{"db_pass": "new", "API_KEY": "abc"}, kv.get("secret/data/app"))
+	*pushReplace = false
+
+	kv.race = map[string]interface{}{"OTHER": "concurrent"}
+	setStub(map[string]string{"DB_PASS": "newer"}, nil)
+	_, er = run()
+	assert.Error(t, er, "a concurrent change aborts the push")
+	assert.Equal(t, map[string]interface{}{"OTHER": "concurrent"}, kv.get("secret/data/app"),
+		"the concurrent change is kept")
+
+	*pushTo = "vault:kv/app"
+	_, er = run()
+	assert.Error(t, er, "check-and-set on KV v1")
+	*pushCAS = false
+	_, er = run()
+	require.NoError(t, er)
+	assert.Equal(t, map[string]interface{}{"DB_PASS": "newer"}, kv.get("kv/app"))
+
+	for _, to := range []string{"vault", "vault:", "consul:secret/app"} {
+		*pushTo = to
+		_, er = run()
+		assert.Errorf(t, er, to)
+	}
+}
+
+func TestExistingKey(t *testing.T) {
+	data := map[string]interface{}{"db_pass": "1", "Db_Pass": "2", "api_key": "3", "TOKEN": "4"}
+	assert.Equal(t, "TOKEN", existingKey(data, nil, "TOKEN"))
+	assert.Equal(t, "Db_Pass", existingKey(data, nil, "DB_PASS"), "the first spelling in sort order")
+	assert.Equal(t, "api_key", existingKey(data, nil, "API_KEY"))
+	assert.Equal(t, "NEW", existingKey(data, nil, "NEW"))
+	assert.Equal(t, "API_KEY", existingKey(data, map[string]string{"api_key": "x", "API_KEY": "y"}, "API_KEY"),
+		"a spelling which was gathered itself")
+}
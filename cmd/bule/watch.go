@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+// signals are the names --signal accepts
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+func parseSignal(name string) (syscall.Signal, error) {
+	name = strings.TrimPrefix(strings.ToUpper(name), "SIG")
+	if sig, ok := signals[name]; ok {
+		return sig, nil
+	}
+	if n, er := strconv.Atoi(name); er == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+	return 0, fmt.Errorf("unknown signal %q", name)
+}
+
+// watch gathers secrets every interval, plus jitter, and rewrites the outputs whose content changed, notifying the
+// consumer after each change. A run where any provider fails writes nothing, so files never lose secrets to a
+// provider outage. Returns when bule is interrupted or terminated.
+func watch(outputs []*output) error {
+	sig, er := parseSignal(*signalName)
+	if er != nil {
+		return er
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	rand.Seed(time.Now().UnixNano())
+	ready := false
+	for {
+		if render(outputs, sig) && !ready {
+			ready = true
+			if er := markReady(); er != nil {
+				return er
+			}
+		}
+
+		wait := *interval
+		if *jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(*jitter)))
+		}
+		log.Debugf("Waiting for next run. wait=%v", wait)
+
+		select {
+		case s := <-stop:
+			log.Infof("Stopping. signal=%v", s)
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// render gathers secrets once and writes the changed outputs. Returns true if every output is up to date.
+func render(outputs []*output, sig syscall.Signal) bool {
+	secrets, er := gather(*providers)
+	if er != nil {
+		log.Infof("Failed to gather secrets, leaving outputs unchanged. err=%v", er)
+		return false
+	}
+
+	changed, er := writeOutputs(outputs, secrets.Map())
+	if len(changed) > 0 {
+		log.Infof("Outputs changed. files=%s", strings.Join(changed, ","))
+		notify(sig)
+	}
+	if er != nil {
+		log.Infof("Failed to write secrets to file. err=%v", er)
+		return false
+	}
+	return true
+}
+
+// notify signals the consumer and runs the change command. Failures are logged, the next change tries again.
+func notify(sig syscall.Signal) {
+	if *signalPidfile != "" {
+		if er := signalPidfileProcess(*signalPidfile, sig); er != nil {
+			log.Infof("Failed to signal process. pidfile=%s err=%v", *signalPidfile, er)
+		}
+	}
+
+	if *signalProcess != "" {
+		pids, er := findProcesses(*signalProcess)
+		if er != nil {
+			log.Infof("Failed to find processes. name=%s err=%v", *signalProcess, er)
+		}
+		for _, pid := range pids {
+			log.Debugf("Signalling process. name=%s pid=%d signal=%v", *signalProcess, pid, sig)
+			if er := syscall.Kill(pid, sig); er != nil {
+				log.Infof("Failed to signal process. name=%s pid=%d err=%v", *signalProcess, pid, er)
+			}
+		}
+	}
+
+	if *onChange != "" {
+		log.Debugf("Running change command. cmd=%s", *onChange)
+		cmd := exec.Command("/bin/sh", "-c", *onChange)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if er := cmd.Run(); er != nil {
+			log.Infof("Change command failed. cmd=%s err=%v", *onChange, er)
+		}
+	}
+}
+
+func signalPidfileProcess(path string, sig syscall.Signal) error {
+	data, er := ioutil.ReadFile(path)
+	if er != nil {
+		return er
+	}
+	pid, er := strconv.Atoi(strings.TrimSpace(string(data)))
+	if er != nil || pid <= 0 {
+		return fmt.Errorf("invalid pid in %s", path)
+	}
+	log.Debugf("Signalling process. pid=%d signal=%v", pid, sig)
+	return syscall.Kill(pid, sig)
+}
+
+// markReady creates the readiness file
+func markReady() error {
+	if *readyFile == "" {
+		return nil
+	}
+	log.Infof("Outputs ready. file=%s", *readyFile)
+	return ioutil.WriteFile(*readyFile, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+)
+
+// stubProvider serves the secrets set with setStub in place of a real provider
+type stubProvider struct{}
+
+var stub struct {
+	sync.Mutex
+	secrets map[string]string
+	er      error
+}
+
+func init() {
+	environ.RegisterProvider("stub", func() (environ.Provider, error) { return stubProvider{}, nil })
+}
+
+func (stubProvider) AddToEnviron(e *environ.Environ) error {
+	stub.Lock()
+	defer stub.Unlock()
+	if stub.er != nil {
+		return stub.er
+	}
+	e.SafeMerge(stub.secrets)
+	return nil
+}
+
+func setStub(secrets map[string]string, er error) {
+	stub.Lock()
+	defer stub.Unlock()
+	stub.secrets, stub.er = secrets, er
+}
+
+// useStub gathers secrets from the stub provider until the returned func is called
+func useStub() func() {
+	saved, savedPolicies := *providers, *policies
+	*providers, *policies = []string{"stub"}, []string{"refuse"}
+	return func() {
+		*providers, *policies = saved, savedPolicies
+		setStub(nil, nil)
+	}
+}
+
+// waitFor polls cond until it holds or a few seconds have passed
+func waitFor(t *testing.T, cond func() bool, msg string) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatal(msg)
+}
+
+func lines(t *testing.T, path string) int {
+	data, er := ioutil.ReadFile(path)
+	if os.IsNotExist(er) {
+		return 0
+	}
+	require.NoError(t, er)
+	return strings.Count(string(data), "\n")
+}
+
+func TestRender(t *testing.T) {
+	dir, er := ioutil.TempDir("", "bule-test-")
+	require.NoError(t, er)
+	defer os.RemoveAll(dir)
+	defer useStub()()
+
+	pidfile, runs := filepath.Join(dir, "app.pid"), filepath.Join(dir, "runs")
+	require.NoError(t, ioutil.WriteFile(pidfile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0600))
+	defer func(p, c string) { *signalPidfile, *onChange = p, c }(*signalPidfile, *onChange)
+	*signalPidfile, *onChange = pidfile, "echo run >> "+runs
+
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	defer signal.Stop(usr1)
+	signalled := func(wait time.Duration) bool {
+		select {
+		case <-usr1:
+			return true
+		case <-time.After(wait):
+			return false
+		}
+	}
+
+	o := &output{Path: filepath.Join(dir, "app.env")}
+	require.NoError(t, o.init(&output{Mode: "0600"}, false))
+	read := func() string {
+		data, er := ioutil.ReadFile(o.Path)
+		require.NoError(t, er)
+		return string(data)
+	}
+
+	setStub(map[string]string{"A": "1"}, nil)
+	assert.True(t, render([]*output{o}, syscall.SIGUSR1))
+	assert.Equal(t, "A=\"1\"", read())
+	assert.True(t, signalled(5*time.Second), "the pidfile process is signalled")
+	assert.Equal(t, 1, lines(t, runs), "the change command runs")
+
+	assert.True(t, render([]*output{o}, syscall.SIGUSR1))
+	assert.False(t, signalled(50*time.Millisecond), "unchanged outputs notify nobody")
+	assert.Equal(t, 1, lines(t, runs))
+
+	setStub(map[string]string{"A": "2"}, errors.New("vault is down"))
+	assert.False(t, render([]*output{o}, syscall.SIGUSR1), "a provider failure")
+	assert.Equal(t, "A=\"1\"", read(), "a provider failure writes nothing")
+	assert.Equal(t, 1, lines(t, runs))
+
+	*onChange = "echo run >> " + runs + "; exit 3"
+	setStub(map[string]string{"A": "2"}, nil)
+	assert.True(t, render([]*output{o}, syscall.SIGUSR1), "a failing change command does not fail the run")
+	assert.Equal(t, "A=\"2\"", read())
+	assert.True(t, signalled(5*time.Second))
+	assert.Equal(t, 2, lines(t, runs))
+
+	*signalPidfile = filepath.Join(dir, "missing.pid")
+	setStub(map[string]string{"A": "3"}, nil)
+	assert.True(t, render([]*output{o}, syscall.SIGUSR1), "a missing pidfile does not fail the run")
+	assert.Equal(t, 3, lines(t, runs), "the change command runs when signalling fails")
+}
+
+func TestMarkReady(t *testing.T) {
+	dir, er := ioutil.TempDir("", "bule-test-")
+	require.NoError(t, er)
+	defer os.RemoveAll(dir)
+	defer func(path string) { *readyFile = path }(*readyFile)
+
+	*readyFile = ""
+	assert.NoError(t, markReady())
+
+	*readyFile = filepath.Join(dir, "ready")
+	require.NoError(t, markReady())
+	data, er := ioutil.ReadFile(*readyFile)
+	require.NoError(t, er)
+	_, er = time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	assert.NoError(t, er)
+
+	*readyFile = filepath.Join(dir, "missing", "ready")
+	assert.Error(t, markReady())
+}
+
+func TestWatch(t *testing.T) {
+	dir, er := ioutil.TempDir("", "bule-test-")
+	require.NoError(t, er)
+	defer os.RemoveAll(dir)
+	defer useStub()()
+
+	defer func(i, j time.Duration, name, ready, cmd string) {
+		*interval, *jitter, *signalName, *readyFile, *onChange = i, j, name, ready, cmd
+	}(*interval, *jitter, *signalName, *readyFile, *onChange)
+	runs := filepath.Join(dir, "runs")
+	*interval, *jitter, *signalName = time.Millisecond, 0, "HUP"
+	*readyFile, *onChange = filepath.Join(dir, "ready"), "echo run >> "+runs
+
+	o := &output{Path: filepath.Join(dir, "app.env")}
+	require.NoError(t, o.init(&output{Mode: "0600"}, false))
+
+	setStub(nil, errors.New("vault is down"))
+	done := make(chan error, 1)
+	go func() { done <- watch([]*output{o}) }()
+
+	setStub(map[string]string{"A": "1"}, nil)
+	waitFor(t, func() bool { return exists(*readyFile) }, "outputs were never marked ready")
+	data, er := ioutil.ReadFile(o.Path)
+	require.NoError(t, er)
+	assert.Equal(t, "A=\"1\"", string(data))
+
+	setStub(map[string]string{"A": "2"}, nil)
+	waitFor(t, func() bool { return lines(t, runs) == 2 }, "the change was never noticed")
+	data, er = ioutil.ReadFile(o.Path)
+	require.NoError(t, er)
+	assert.Equal(t, "A=\"2\"", string(data))
+
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	select {
+	case er := <-done:
+		assert.NoError(t, er)
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not stop on SIGTERM")
+	}
+	assert.Equal(t, 2, lines(t, runs), "unchanged outputs notify nobody")
+}
+
+func exists(path string) bool {
+	_, er := os.Stat(path)
+	return er == nil
+}
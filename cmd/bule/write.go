@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+// fileOpts are the permissions written files are given
+type fileOpts struct {
+	mode  os.FileMode
+	uid   int
+	gid   int
+	force bool
+}
+
+// newFileOpts parses an octal mode and an owner and group, given by name or id. An empty owner or group leaves it
+// unchanged.
+func newFileOpts(mode, owner, group string, force bool) (*fileOpts, error) {
+	opts := &fileOpts{uid: -1, gid: -1, force: force}
+
+	m, er := strconv.ParseUint(mode, 8, 32)
+	if er != nil || m > 0777 {
+		return nil, fmt.Errorf("invalid mode %q", mode)
+	}
+	opts.mode = os.FileMode(m)
+
+	if owner != "" {
+		if opts.uid, er = lookupUID(owner); er != nil {
+			return nil, er
+		}
+	}
+	if group != "" {
+		if opts.gid, er = lookupGID(group); er != nil {
+			return nil, er
+		}
+	}
+	return opts, nil
+}
+
+func lookupUID(owner string) (int, error) {
+	if id, er := strconv.Atoi(owner); er == nil {
+		return id, nil
+	}
+	u, er := user.Lookup(owner)
+	if er != nil {
+		return -1, er
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+func lookupGID(group string) (int, error) {
+	if id, er := strconv.Atoi(group); er == nil {
+		return id, nil
+	}
+	g, er := user.LookupGroup(group)
+	if er != nil {
+		return -1, er
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// checkDir refuses directories anyone may write to, where another user could swap the file out from under us,
+// unless forced
+func (o *fileOpts) checkDir(dir string) error {
+	fi, er := os.Stat(dir)
+	if er != nil {
+		return er
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if fi.Mode().Perm()&0002 != 0 && !o.force {
+		return fmt.Errorf("refusing to write to world writable directory %s without --force", dir)
+	}
+	return nil
+}
+
+// apply gives an existing file its mode and owner
+func (o *fileOpts) apply(path string) error {
+	if er := os.Chmod(path, o.mode); er != nil {
+		return er
+	}
+	if o.uid != -1 || o.gid != -1 {
+		return os.Chown(path, o.uid, o.gid)
+	}
+	return nil
+}
+
+// stageFile writes and syncs data to a temporary file next to path, with its mode and owner already set, and returns
+// the name of the temporary file. Committing it replaces path atomically, so readers see either the old file or the
+// new one, never a partial write.
+func stageFile(path string, data []byte, o *fileOpts) (string, error) {
+	dir := filepath.Dir(path)
+	if er := o.checkDir(dir); er != nil {
+		return "", er
+	}
+
+	tmp, er := ioutil.TempFile(dir, "."+filepath.Base(path)+".")
+	if er != nil {
+		return "", er
+	}
+	fail := func(er error) (string, error) {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", er
+	}
+
+	if er := tmp.Chmod(o.mode); er != nil {
+		return fail(er)
+	}
+	if o.uid != -1 || o.gid != -1 {
+		if er := tmp.Chown(o.uid, o.gid); er != nil {
+			return fail(er)
+		}
+	}
+	if _, er := tmp.Write(data); er != nil {
+		return fail(er)
+	}
+	if er := tmp.Sync(); er != nil {
+		return fail(er)
+	}
+	if er := tmp.Close(); er != nil {
+		return fail(er)
+	}
+	return tmp.Name(), nil
+}
+
+// commitFile renames the staged temporary file over path
+func commitFile(tmp, path string) error {
+	if er := os.Rename(tmp, path); er != nil {
+		os.Remove(tmp)
+		return er
+	}
+	log.Debugf("Wrote file. file=%s", path)
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes the rename of a file in dir durable
+func syncDir(dir string) error {
+	d, er := os.Open(dir)
+	if er != nil {
+		return er
+	}
+	defer d.Close()
+	return d.Sync()
+}
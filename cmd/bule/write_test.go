@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFileOpts(t *testing.T) {
+	o, er := newFileOpts("0640", "", "", false)
+	require.NoError(t, er)
+	assert.Equal(t, os.FileMode(0640), o.mode)
+	assert.Equal(t, -1, o.uid, "an empty owner leaves it unchanged")
+	assert.Equal(t, -1, o.gid)
+
+	o, er = newFileOpts("400", "0", "0", true)
+	require.NoError(t, er)
+	assert.Equal(t, os.FileMode(0400), o.mode)
+	assert.Equal(t, 0, o.uid)
+	assert.Equal(t, 0, o.gid)
+	assert.True(t, o.force)
+
+	for _, mode := range []string{"", "rw", "0999", "1777", "-1"} {
+		_, er := newFileOpts(mode, "", "", false)
+		assert.Errorf(t, er, "mode %q", mode)
+	}
+	_, er = newFileOpts("0600", "no-such-user-here", "", false)
+	assert.Error(t, er)
+	_, er = newFileOpts("0600", "", "no-such-group-here", false)
+	assert.Error(t, er)
+}
+
+func TestCheckDir(t *testing.T) {
+	dir, er := ioutil.TempDir("", "bule-test-")
+	require.NoError(t, er)
+	defer os.RemoveAll(dir)
+
+	o := &fileOpts{mode: 0600, uid: -1, gid: -1}
+	assert.NoError(t, o.checkDir(dir))
+
+	require.NoError(t, os.Chmod(dir, 0777))
+	er = o.checkDir(dir)
+	if assert.Error(t, er, "world writable") {
+		assert.Contains(t, er.Error(), "--force")
+	}
+	o.force = true
+	assert.NoError(t, o.checkDir(dir))
+
+	file := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(file, nil, 0600))
+	assert.Error(t, o.checkDir(file), "not a directory")
+	assert.Error(t, o.checkDir(filepath.Join(dir, "missing")))
+}
+
+func TestStageFile(t *testing.T) {
+	dir, er := ioutil.TempDir("", "bule-test-")
+	require.NoError(t, er)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "secrets.env")
+	require.NoError(t, ioutil.WriteFile(path, []byte("OLD=1\n"), 0644))
+	o := &fileOpts{mode: 0640, uid: -1, gid: -1}
+
+	tmp, er := stageFile(path, []byte("NEW=1\n"), o)
+	require.NoError(t, er)
+	assert.Equal(t, dir, filepath.Dir(tmp))
+	data, er := ioutil.ReadFile(path)
+	require.NoError(t, er)
+	assert.Equal(t, "OLD=1\n", string(data), "staging leaves the file alone")
+
+	require.NoError(t, commitFile(tmp, path))
+	data, er = ioutil.ReadFile(path)
+	require.NoError(t, er)
+	assert.Equal(t, "NEW=1\n", string(data))
+	fi, er := os.Stat(path)
+	require.NoError(t, er)
+	assert.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+	assertOnly(t, dir, "secrets.env")
+
+	// a rename over a directory fails, and the staged file is removed
+	target := filepath.Join(dir, "taken")
+	require.NoError(t, os.MkdirAll(filepath.Join(target, "child"), 0755))
+	tmp, er = stageFile(target, []byte("x"), o)
+	require.NoError(t, er)
+	assert.Error(t, commitFile(tmp, target))
+	assertOnly(t, dir, "secrets.env", "taken")
+
+	require.NoError(t, os.Chmod(dir, 0777))
+	_, er = stageFile(path, []byte("x"), o)
+	assert.Error(t, er, "world writable directory without --force")
+	assertOnly(t, dir, "secrets.env", "taken")
+}
+
+// assertOnly checks the directory holds just the named entries
+func assertOnly(t *testing.T, dir string, names ...string) {
+	infos, er := ioutil.ReadDir(dir)
+	require.NoError(t, er)
+	got := make([]string, 0, len(infos))
+	for _, fi := range infos {
+		got = append(got, fi.Name())
+	}
+	assert.ElementsMatch(t, names, got)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+const redacted = "[REDACTED]"
+
+// commandArgs returns the command and its arguments, with the arguments rendered as templates against the secrets
+// and variables set by vest when enabled. The command name itself is never rendered.
+func commandArgs(conf *config, command []string, secrets *environ.Environ, vars map[string]string) ([]string, error) {
+	if !conf.TemplateArgs || len(command) < 2 {
+		return command, nil
+	}
+
+	data := merge(secrets.Map(), vars)
+
+	args := make([]string, len(command))
+	logged := make([]string, len(command))
+	args[0], logged[0] = command[0], command[0]
+	for i, arg := range command[1:] {
+		rendered, er := environ.RenderString(arg, data, conf.TemplateStrict)
+		if er != nil {
+			return nil, fmt.Errorf("failed to render argument %d: %v", i+1, er)
+		}
+
+		args[i+1], logged[i+1] = rendered, rendered
+		if rendered != arg {
+			logged[i+1] = redacted
+		}
+	}
+
+	log.Debugf("Rendered command arguments. args=%q", logged)
+	return args, nil
+}
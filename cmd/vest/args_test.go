@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+func TestCommandArgs(t *testing.T) {
+	var buf bytes.Buffer
+	defer log.SetLogger(log.GetLogger())
+	log.SetLogger(newLogger("debug", &buf))
+
+	secrets := environ.New()
+	secrets.Merge(map[string]string{"DB_PASSWORD": "hunter2"})
+	vars := map[string]string{"VEST_USER": "app"}
+	command := []string{"psql", "--password={{ .DB_PASSWORD }}", "--user={{ .VEST_USER }}", "--verbose", "{{ .MISSING }}"}
+
+	args, er := commandArgs(&config{}, command, secrets, vars)
+	require.NoError(t, er)
+	assert.Equal(t, command, args, "arguments are rendered only with --template-args")
+
+	args, er = commandArgs(&config{TemplateArgs: true}, command, secrets, vars)
+	require.NoError(t, er)
+	assert.Equal(t, []string{"psql", "--password=hunter2", "--user=app", "--verbose", ""}, args)
+	assert.Contains(t, buf.String(), `[\"psql\" \"[REDACTED]\" \"[REDACTED]\" \"--verbose\" \"[REDACTED]\"]`)
+	assert.NotContains(t, buf.String(), "hunter2", "rendered values are never logged")
+
+	_, er = commandArgs(&config{TemplateArgs: true, TemplateStrict: true}, command, secrets, vars)
+	if assert.Error(t, er, "an undefined key in strict mode") {
+		assert.Contains(t, er.Error(), "argument 4")
+	}
+
+	args, er = commandArgs(&config{TemplateArgs: true}, []string{"{{ .DB_PASSWORD }}"}, secrets, vars)
+	require.NoError(t, er)
+	assert.Equal(t, []string{"{{ .DB_PASSWORD }}"}, args, "the command name is never rendered")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+const (
+	// deliverEnv delivers secrets to the command in its environment
+	deliverEnv = "env"
+	// deliverFd delivers secrets to the command marshalled into an inherited file descriptor
+	deliverFd = "fd"
+
+	secretsFdEnvVar     = "VEST_SECRETS_FD"
+	secretsFormatEnvVar = "VEST_SECRETS_FORMAT"
+
+	// the most we can write to a pipe without a reader before blocking
+	maxPipeSize = 64 * 1024
+)
+
+// secretsFile marshals the secrets into a sealed memfd, or a pipe where memfds are unavailable, and returns it
+// ready to be read from the start
+func secretsFile(conf *config, secrets *environ.Environ) (*os.File, error) {
+	buf := new(bytes.Buffer)
+	if er := secrets.SetMarshaller(conf.SecretsFormat); er != nil {
+		return nil, er
+	}
+	if er := secrets.Write(buf); er != nil {
+		return nil, fmt.Errorf("failed to marshal secrets: %v", er)
+	}
+
+	fd, er := memfd(buf.Bytes())
+	if er != nil {
+		log.Debugf("Failed to deliver secrets with memfd, falling back to pipe. err=%v", er)
+		if fd, er = pipefd(buf.Bytes()); er != nil {
+			return nil, fmt.Errorf("failed to deliver secrets: %v", er)
+		}
+	}
+	return os.NewFile(uintptr(fd), "vest-secrets"), nil
+}
+
+// reservedFd is the file descriptor held for the secrets by reserveFd, or -1
+var reservedFd = -1
+
+// reserveFd holds the file descriptor n open on /dev/null until the secrets are moved to it, so nothing vest opens
+// while gathering secrets, such as the runtime's network poller or a connection to a provider, takes it first. Does
+// nothing if n is already open.
+func reserveFd(n int) error {
+	if _, er := unix.FcntlInt(uintptr(n), unix.F_GETFD, 0); er != unix.EBADF {
+		return nil
+	}
+
+	null, er := unix.Open(os.DevNull, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if er != nil {
+		return er
+	}
+	if null != n {
+		defer unix.Close(null)
+		if er := unix.Dup2(null, n); er != nil {
+			return er
+		}
+		unix.CloseOnExec(n)
+	}
+	reservedFd = n
+	return nil
+}
+
+// inheritFd moves the file to the given file descriptor number so the command inherits it on exec. n must be unused
+// or reserved, as replacing a descriptor vest is using would close it under whatever holds it.
+func inheritFd(f *os.File, n int) error {
+	if int(f.Fd()) == n {
+		_, er := unix.FcntlInt(uintptr(n), unix.F_SETFD, 0)
+		return er
+	}
+	if n != reservedFd {
+		if _, er := unix.FcntlInt(uintptr(n), unix.F_GETFD, 0); er != unix.EBADF {
+			return fmt.Errorf("file descriptor %d is already in use, choose another with --deliver-fd", n)
+		}
+	}
+
+	// Dup2 clears close-on-exec on the new descriptor
+	if er := unix.Dup2(int(f.Fd()), n); er != nil {
+		return er
+	}
+	return f.Close()
+}
+
+// pipefd writes data into a new pipe and returns the read end
+func pipefd(data []byte) (int, error) {
+	if len(data) > maxPipeSize {
+		return -1, fmt.Errorf("secrets too large for pipe. size=%d max=%d", len(data), maxPipeSize)
+	}
+
+	p := make([]int, 2)
+	if er := unix.Pipe(p); er != nil {
+		return -1, er
+	}
+	defer unix.Close(p[1])
+
+	for written := 0; written < len(data); {
+		n, er := unix.Write(p[1], data[written:])
+		if er != nil {
+			unix.Close(p[0])
+			return -1, er
+		}
+		written += n
+	}
+	return p[0], nil
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"errors"
+)
+
+// memfd is not supported on darwin, secrets are delivered with a pipe instead
+func memfd(data []byte) (int, error) {
+	return -1, errors.New("memfd is not supported on darwin")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const seals = unix.F_SEAL_SEAL | unix.F_SEAL_SHRINK | unix.F_SEAL_GROW | unix.F_SEAL_WRITE
+
+// memfd writes data into a new anonymous memory backed file, seals it against any further modification and
+// rewinds it for the reader
+func memfd(data []byte) (int, error) {
+	fd, er := unix.MemfdCreate("vest-secrets", unix.MFD_ALLOW_SEALING)
+	if er != nil {
+		return -1, er
+	}
+
+	for written := 0; written < len(data); {
+		n, er := unix.Write(fd, data[written:])
+		if er != nil {
+			unix.Close(fd)
+			return -1, er
+		}
+		written += n
+	}
+
+	if _, er := unix.FcntlInt(uintptr(fd), unix.F_ADD_SEALS, seals); er != nil {
+		unix.Close(fd)
+		return -1, er
+	}
+	if _, er := unix.Seek(fd, 0, 0); er != nil {
+		unix.Close(fd)
+		return -1, er
+	}
+	return fd, nil
+}
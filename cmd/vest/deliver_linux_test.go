@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestMemfdSealed(t *testing.T) {
+	fd, er := memfd([]byte("A=1\n"))
+	if er != nil {
+		t.Skipf("memfd unavailable: %v", er)
+	}
+	defer unix.Close(fd)
+
+	got, er := unix.FcntlInt(uintptr(fd), unix.F_GET_SEALS, 0)
+	require.NoError(t, er)
+	assert.Equal(t, seals, got)
+
+	_, er = unix.Write(fd, []byte("B=2\n"))
+	assert.Error(t, er, "sealed against writes")
+
+	buf := make([]byte, 16)
+	n, er := unix.Read(fd, buf)
+	require.NoError(t, er)
+	assert.Equal(t, "A=1\n", string(buf[:n]), "rewound for the reader")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	env "github.com/caarlos0/env/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+)
+
+func TestSecretsFile(t *testing.T) {
+	secrets := environ.New()
+	secrets.Merge(map[string]string{"DB_PASS": "hunter2", "API_KEY": "abc"})
+
+	f, er := secretsFile(&config{SecretsFormat: "json"}, secrets)
+	require.NoError(t, er)
+	defer f.Close()
+	data, er := ioutil.ReadAll(f)
+	require.NoError(t, er)
+	var m map[string]string
+	require.NoError(t, json.Unmarshal(data, &m))
+	assert.Equal(t, map[string]string{"DB_PASS": "hunter2", "API_KEY": "abc"}, m)
+
+	_, er = secretsFile(&config{SecretsFormat: "nope"}, secrets)
+	assert.Error(t, er)
+}
+
+func TestPipefd(t *testing.T) {
+	fd, er := pipefd([]byte("A=1\n"))
+	require.NoError(t, er)
+	f := os.NewFile(uintptr(fd), "pipe")
+	defer f.Close()
+	data, er := ioutil.ReadAll(f)
+	require.NoError(t, er)
+	assert.Equal(t, "A=1\n", string(data))
+
+	_, er = pipefd(make([]byte, maxPipeSize+1))
+	assert.Error(t, er, "more than a pipe holds without a reader")
+}
+
+func TestInheritFd(t *testing.T) {
+	free := func() int {
+		for n := 64; ; n++ {
+			if _, er := unix.FcntlInt(uintptr(n), unix.F_GETFD, 0); er == unix.EBADF {
+				return n
+			}
+		}
+	}
+
+	f, er := ioutil.TempFile("", "vest-test-")
+	require.NoError(t, er)
+	defer os.Remove(f.Name())
+	n := free()
+	require.NoError(t, inheritFd(f, n))
+	defer unix.Close(n)
+	flags, er := unix.FcntlInt(uintptr(n), unix.F_GETFD, 0)
+	require.NoError(t, er)
+	assert.Zero(t, flags&unix.FD_CLOEXEC, "inherited on exec")
+
+	busy, er := ioutil.TempFile("", "vest-test-")
+	require.NoError(t, er)
+	defer os.Remove(busy.Name())
+	defer busy.Close()
+	g, er := ioutil.TempFile("", "vest-test-")
+	require.NoError(t, er)
+	defer os.Remove(g.Name())
+	defer g.Close()
+	er = inheritFd(g, int(busy.Fd()))
+	if assert.Error(t, er, "a descriptor in use is not replaced") {
+		assert.True(t, strings.Contains(er.Error(), "already in use"))
+	}
+
+	defer func(fd int) { reservedFd = fd }(reservedFd)
+	n = free()
+	require.NoError(t, reserveFd(n))
+	assert.Equal(t, n, reservedFd)
+	flags, er = unix.FcntlInt(uintptr(n), unix.F_GETFD, 0)
+	require.NoError(t, er)
+	assert.NotZero(t, flags&unix.FD_CLOEXEC, "the reservation is not inherited")
+	require.NoError(t, inheritFd(g, n), "a reserved descriptor is replaced")
+	unix.Close(n)
+}
+
+func TestDeliverFdEnv(t *testing.T) {
+	os.Setenv("VEST_DELIVER", "fd")
+	os.Setenv("VEST_DELIVER_FD", "7")
+	os.Setenv("VEST_DELIVER_FORMAT", "dotenv")
+	defer os.Unsetenv("VEST_DELIVER")
+	defer os.Unsetenv("VEST_DELIVER_FD")
+	defer os.Unsetenv("VEST_DELIVER_FORMAT")
+
+	conf := new(config)
+	require.NoError(t, env.Parse(conf))
+	app, cmds := newApp(conf)
+	_, er := parseArgs(conf, app, cmds, []string{"--", "printenv"})
+	require.NoError(t, er)
+	assert.Equal(t, deliverFd, conf.Deliver)
+	assert.Equal(t, 7, conf.SecretsFd)
+
+	secrets := environ.New()
+	secrets.Merge(map[string]string{"DB_PASS": "hunter2"})
+	vars := childEnv(conf, secrets, nil)
+	assert.Contains(t, vars, "VEST_SECRETS_FD=7")
+	assert.Contains(t, vars, "VEST_SECRETS_FORMAT=dotenv")
+	assert.NotContains(t, vars, "DB_PASS=hunter2")
+}
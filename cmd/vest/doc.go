@@ -11,6 +11,8 @@ import (
 	"text/template"
 )
 
+const author = "Lumos Labs"
+
 var (
 	version      = "dev"
 	commit, date string
@@ -37,10 +39,17 @@ func usage() string {
 		},
 	}
 	t := template.Must(template.New("usage").Funcs(funcs).Parse(`
-Usage: {{ .Self }} user-spec command [args]
-   eg: {{ .Self }} myuser bash
-       {{ .Self }} nobody:root bash -c 'whoami && id'
-       {{ .Self }} 1000:1 id
+Load secrets from secret providers into the environment, then exec a command as the given user.
+
+  The gosu compatible form is still supported when the first argument is not a flag or command:
+
+    {{ .Self }} user-spec command [args]
+    eg: {{ .Self }} myuser bash
+        {{ .Self }} nobody:root bash -c 'whoami && id'
+        {{ .Self }} 1000:1 id
+
+  Everything after -- is always the command to run. A vest command given arguments it does not take runs the
+  command of the same name from PATH instead, e.g. {{ .Self }} env FOO=1 app.
 {{- if .EnvVars }}
 
   Environment Variables:
@@ -51,17 +60,14 @@ Usage: {{ .Self }} user-spec command [args]
   {{- end }}
   {{- end }}
 {{- end }}
-{{ .Self }} version: {{ .Version }}
-{{ .Self }} license: GPL-3 (full text at https://github.com/lumoslabs/vestibule)
+  {{ .Self }} license: GPL-3 (full text at https://github.com/lumoslabs/vestibule)
 `))
 	var b bytes.Buffer
 	template.Must(t, t.Execute(&b, struct {
 		Self    string
-		Version string
 		EnvVars []map[string]string
 	}{
 		Self:    filepath.Base(os.Args[0]),
-		Version: appVersion(),
 		EnvVars: secretProviderEnvVars,
 	}))
 	return strings.TrimSpace(b.String()) + "\n"
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/opencontainers/runc/libcontainer/user"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+// cleanEnvVars are the variables passed through to the command from the environment of vest when using clean-env
+var cleanEnvVars = []string{"HOME", "PATH"}
+
+// populate returns a new Environ populated from the configured providers. Provider failures are only fatal in
+// strict mode.
+func populate(conf *config) (*environ.Environ, error) {
+	secrets, er := gather(conf)
+	if er != nil && (secrets == nil || conf.Strict) {
+		return nil, er
+	}
+	return secrets, nil
+}
+
+// gather returns a new Environ populated from the configured providers along with any provider failures. Later calls
+// populate secrets again from the provider configuration the first consumed.
+func gather(conf *config) (*environ.Environ, error) {
+	environ.Protect(conf.Protected...)
+
+	secrets := environ.New()
+	secrets.UpcaseKeys = conf.UpcaseVars
+	if er := secrets.SetPolicies(conf.Policies); er != nil {
+		return nil, er
+	}
+	return secrets, conf.providerEnv.Populate(secrets, conf.Providers)
+}
+
+// childEnv returns the environment the command will be run with, including any variables set by vest itself.
+// Secrets are only included when delivered in the environment and not served.
+func childEnv(conf *config, secrets *environ.Environ, vars map[string]string) []string {
+	e := secrets
+	switch {
+	case conf.Serve:
+		e = environ.New()
+		e.UpcaseKeys = conf.UpcaseVars
+	case conf.Deliver == deliverFd:
+		e = environ.New()
+		e.UpcaseKeys = conf.UpcaseVars
+		e.Set(secretsFdEnvVar, strconv.Itoa(conf.SecretsFd))
+		e.Set(secretsFormatEnvVar, conf.SecretsFormat)
+	}
+	e.Merge(vars)
+
+	if !conf.CleanEnv {
+		inherited := os.Environ()
+		if conf.Serve || conf.Deliver == deliverFd {
+			inherited = withoutProviderConfig(inherited)
+		}
+		e.SafeAppend(inherited)
+		return e.Slice()
+	}
+
+	for _, name := range cleanEnvVars {
+		if v, ok := os.LookupEnv(name); ok {
+			e.SafeMerge(map[string]string{name: v})
+		}
+	}
+	return e.Slice()
+}
+
+// withoutProviderConfig returns the KEY=value items without the variables configuring secret providers, so a command
+// given its secrets out of band is not also given the credentials to fetch them
+func withoutProviderConfig(items []string) []string {
+	var names []string
+	for _, vars := range providerEnvVars {
+		for name := range vars {
+			names = append(names, name)
+		}
+	}
+
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		if !environ.MatchAny(names, strings.SplitN(item, "=", 2)[0]) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// execCommand switches to the configured user, if any, and replaces vest with the configured command. vars are set
+// in the environment of the command regardless of how secrets are delivered.
+func execCommand(conf *config, secrets *environ.Environ, vars map[string]string) error {
+	if conf.Serve && conf.Deliver == deliverFd {
+		return fmt.Errorf("serving secrets and delivering them with an fd can not be combined")
+	}
+	if conf.Deliver == deliverFd && conf.SecretsFd < 3 {
+		return fmt.Errorf("invalid secrets file descriptor %d, must be 3 or more", conf.SecretsFd)
+	}
+
+	var usr *user.ExecUser
+	if conf.User != "" {
+		os.Unsetenv("HOME")
+		secrets.Delete("HOME")
+
+		u, er := getUser(conf.User)
+		if er != nil {
+			return fmt.Errorf("unable to find %q: %v", conf.User, er)
+		}
+		usr = u
+	}
+
+	name, er := exec.LookPath(conf.Command[0])
+	if er != nil {
+		return er
+	}
+
+	if conf.Explain {
+		return explain(os.Stdout, conf, usr, name, secrets)
+	}
+
+	// secrets written to files are redacted too, so take the values before they leave the Environ
+	values := make([]string, 0, secrets.Len())
+	for _, v := range secrets.Map() {
+		values = append(values, v)
+	}
+	for _, f := range secrets.Files() {
+		values = append(values, f.Content)
+	}
+
+	fileVars, cleanup, er := materialize(conf, secrets, usr)
+	if er != nil {
+		return fmt.Errorf("failed to write secret files: %v", er)
+	}
+	vars = merge(vars, fileVars)
+
+	// the socket is created while vest may still chown it to the user
+	var server *secretServer
+	if conf.Serve {
+		server, er = newSecretServer(conf, secrets, usr)
+		if er != nil {
+			cleanup()
+			return fmt.Errorf("failed to start secret server: %v", er)
+		}
+		defer server.Close()
+		server.files = fileVars
+		go server.Serve(conf, conf.RefreshInterval)
+		vars = merge(vars, map[string]string{socketEnvVar: server.path})
+	}
+
+	if usr != nil {
+		if er := SetupUser(usr); er != nil {
+			cleanup()
+			return fmt.Errorf("failed switching to %q: %v", conf.User, er)
+		}
+	}
+
+	args, er := commandArgs(conf, conf.Command, secrets, vars)
+	if er != nil {
+		cleanup()
+		return er
+	}
+
+	if supervised(conf) {
+		defer cleanup()
+		return superviseCommand(conf, secrets, vars, name, args, values, server)
+	}
+
+	environment := childEnv(conf, secrets, vars)
+	if conf.Deliver == deliverFd {
+		f, er := secretsFile(conf, secrets)
+		if er != nil {
+			return er
+		}
+		if er := inheritFd(f, conf.SecretsFd); er != nil {
+			return er
+		}
+	}
+
+	if er := syscall.Exec(name, args, environment); er != nil {
+		return fmt.Errorf("exec failed: %v", er)
+	}
+	return nil
+}
+
+// supervised returns true if the command is run as a child of vest. Writing secrets to files, serving secrets and
+// redacting output all need vest to outlive the command.
+func supervised(conf *config) bool {
+	return conf.Supervise || conf.Serve || conf.Redact || len(conf.FileKeys) > 0
+}
+
+// superviseCommand runs the command as a child of vest and exits with its exit code. With redaction enabled the
+// output of the command is piped through vest, replacing the secret values, including those the server refreshes.
+func superviseCommand(conf *config, secrets *environ.Environ, vars map[string]string, name string, args []string, values []string, server *secretServer) error {
+	cmd := &exec.Cmd{
+		Path:   name,
+		Args:   args,
+		Env:    childEnv(conf, secrets, vars),
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+
+	if conf.Redact {
+		stdout := newRedactor(os.Stdout, values, conf.RedactMinLength, conf.RedactMinEntropy)
+		stderr := newRedactor(os.Stderr, values, conf.RedactMinLength, conf.RedactMinEntropy)
+		log.Debugf("Redacting secrets from command output. count=%d", len(stdout.secrets))
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		if server != nil {
+			server.onRefresh(func(values []string) {
+				stdout.Add(values)
+				stderr.Add(values)
+			})
+		}
+		defer stdout.Flush()
+		defer stderr.Flush()
+	}
+
+	if conf.Deliver == deliverFd {
+		f, er := secretsFile(conf, secrets)
+		if er != nil {
+			return er
+		}
+		defer f.Close()
+
+		cmd.ExtraFiles = make([]*os.File, conf.SecretsFd-2)
+		cmd.ExtraFiles[conf.SecretsFd-3] = f
+	}
+
+	code, er := supervise(cmd)
+	if er != nil {
+		return er
+	}
+	if code != 0 {
+		return &exitError{code}
+	}
+	return nil
+}
+
+// explain writes a description of what would be run to w. Secret values are never written.
+func explain(w io.Writer, conf *config, usr *user.ExecUser, name string, secrets *environ.Environ) error {
+	keys := sortedKeys(secrets.Map())
+
+	who := fmt.Sprintf("current (uid=%d gid=%d)", syscall.Getuid(), syscall.Getgid())
+	if usr != nil {
+		who = fmt.Sprintf("%s (uid=%d gid=%d groups=%v home=%s)", conf.User, usr.Uid, usr.Gid, usr.Sgids, usr.Home)
+	}
+
+	environment := "inherited"
+	if conf.CleanEnv {
+		environment = fmt.Sprintf("clean (passing through %s)", strings.Join(cleanEnvVars, ", "))
+	}
+
+	var files []string
+	for _, key := range keys {
+		if environ.MatchAny(conf.FileKeys, key) {
+			files = append(files, key)
+		}
+	}
+
+	mode := "exec"
+	if supervised(conf) {
+		mode = "supervise"
+	}
+
+	command := fmt.Sprintf("%s %q", name, conf.Command)
+	if conf.TemplateArgs {
+		command += " (arguments rendered as templates)"
+	}
+
+	delivery := "environment"
+	switch {
+	case conf.Serve:
+		delivery = "secret server on a unix socket in " + socketEnvVar
+		if conf.Socket != "" {
+			delivery = "secret server on " + conf.Socket
+		}
+	case conf.Deliver == deliverFd:
+		delivery = fmt.Sprintf("%s on fd %d", conf.SecretsFormat, conf.SecretsFd)
+	}
+
+	_, er := fmt.Fprintf(w, "user:        %s\ncommand:     %s\nmode:        %s\nproviders:   %s\nenvironment: %s\ndelivery:    %s\nsecrets:     %s\nfiles:       %s\n",
+		who,
+		command,
+		mode,
+		strings.Join(conf.Providers, ", "),
+		environment,
+		delivery,
+		strings.Join(keys, ", "),
+		strings.Join(files, ", "),
+	)
+	return er
+}
+
+func getUser(usr string) (*user.ExecUser, error) {
+	defaultExecUser := user.ExecUser{
+		Uid:  syscall.Getuid(),
+		Gid:  syscall.Getgid(),
+		Home: "/",
+	}
+	passwdPath, err := user.GetPasswdPath()
+	if err != nil {
+		return nil, err
+	}
+	groupPath, err := user.GetGroupPath()
+	if err != nil {
+		return nil, err
+	}
+
+	return user.GetExecUserPath(usr, &defaultExecUser, passwdPath, groupPath)
+}
+
+// sortedKeys returns the sorted keys of the map
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// merge returns a new map with the contents of every given map, later maps winning
+func merge(maps ...map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for _, m := range maps {
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+	return merged
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/opencontainers/runc/libcontainer/user"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+const (
+	// fileEnvVarSuffix is appended to a key to name the variable holding the path of its file
+	fileEnvVarSuffix = "_FILE"
+
+	secretFileMode = os.FileMode(0400)
+	secretDirMode  = os.FileMode(0700)
+)
+
+// shmDir is tmpfs on linux, so secrets written there never touch a disk
+var shmDir = "/dev/shm"
+
+// filesDir returns the configured base directory for secret files, or shmDir if it is a tmpfs. Secrets are never
+// written to a disk unasked, so without either it is an error.
+func filesDir(conf *config) (string, error) {
+	if conf.FilesDir != "" {
+		return conf.FilesDir, nil
+	}
+	if isTmpfs(shmDir) {
+		return shmDir, nil
+	}
+	return "", fmt.Errorf("%s is not a tmpfs, set --files-dir to write secret files", shmDir)
+}
+
+// secretFiles returns the files the command reads secrets from: a <KEY>_FILE per secret matching the file keys,
+// which are moved out of the Environ, and the Files added by providers
+func secretFiles(keys []string, secrets *environ.Environ) []environ.File {
+	files := secrets.Files()
+	for key, v := range secrets.Extract(keys...) {
+		files = append(files, environ.File{Var: key + fileEnvVarSuffix, Content: v})
+	}
+	return files
+}
+
+// materialize writes the secrets the command reads from files. A supervised command gets every file in a new private
+// directory owned by the user, removed by the returned func; the variables holding their paths are returned. Provider
+// files of a command vest execs are written to their own paths instead, as nothing is left to remove them.
+func materialize(conf *config, secrets *environ.Environ, usr *user.ExecUser) (map[string]string, func(), error) {
+	noop := func() {}
+
+	uid, gid := syscall.Getuid(), syscall.Getgid()
+	if usr != nil {
+		uid, gid = usr.Uid, usr.Gid
+	}
+
+	if !supervised(conf) {
+		return nil, noop, secrets.WriteFiles(uid, gid)
+	}
+
+	return writePrivateFiles(conf, secretFiles(conf.FileKeys, secrets), uid, gid)
+}
+
+// writePrivateFiles writes the files into a new private directory owned by uid and gid, returning the variables set
+// to their paths and a func which removes the directory
+func writePrivateFiles(conf *config, files []environ.File, uid, gid int) (map[string]string, func(), error) {
+	noop := func() {}
+	if len(files) == 0 {
+		return nil, noop, nil
+	}
+
+	base, er := filesDir(conf)
+	if er != nil {
+		return nil, noop, er
+	}
+	dir, er := ioutil.TempDir(base, "vest-")
+	if er != nil {
+		return nil, noop, er
+	}
+	cleanup := func() {
+		log.Debugf("Removing secret files. dir=%s", dir)
+		if er := os.RemoveAll(dir); er != nil {
+			log.Infof("Failed to remove secret files. dir=%s err=%v", dir, er)
+		}
+	}
+
+	vars, er := writeSecretFiles(dir, files, uid, gid)
+	if er != nil {
+		cleanup()
+		return nil, noop, er
+	}
+	return vars, cleanup, nil
+}
+
+// writeSecretFiles writes each file into dir, named by its variable, and returns the variables set to their paths
+func writeSecretFiles(dir string, files []environ.File, uid, gid int) (map[string]string, error) {
+	if er := os.Chmod(dir, secretDirMode); er != nil {
+		return nil, er
+	}
+
+	vars := make(map[string]string, len(files))
+	for _, file := range files {
+		if _, ok := vars[file.Var]; ok {
+			return nil, fmt.Errorf("%s is set by more than one secret file", file.Var)
+		}
+		path := filepath.Join(dir, file.Var)
+		log.Debugf("Writing secret file. var=%s file=%s", file.Var, path)
+
+		if er := writeSecretFile(path, file.Content, uid, gid); er != nil {
+			return nil, er
+		}
+		vars[file.Var] = path
+	}
+
+	return vars, os.Chown(dir, uid, gid)
+}
+
+func writeSecretFile(path, content string, uid, gid int) error {
+	f, er := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, secretFileMode)
+	if er != nil {
+		return er
+	}
+	if _, er := f.WriteString(content); er != nil {
+		f.Close()
+		return fmt.Errorf("failed to write secret file %s: %v", path, er)
+	}
+	if er := f.Chmod(secretFileMode); er != nil {
+		f.Close()
+		return er
+	}
+	if er := f.Chown(uid, gid); er != nil {
+		f.Close()
+		return er
+	}
+	return f.Close()
+}
+
+// replaceSecretFile replaces the content of a secret file written by writeSecretFiles, owned by vest as it runs now
+func replaceSecretFile(path, content string) error {
+	tmp := path + ".new"
+	os.Remove(tmp)
+	if er := writeSecretFile(tmp, content, syscall.Getuid(), syscall.Getgid()); er != nil {
+		os.Remove(tmp)
+		return er
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+)
+
+func TestFilesDir(t *testing.T) {
+	dir, er := filesDir(&config{FilesDir: "/run/secrets"})
+	assert.NoError(t, er)
+	assert.Equal(t, "/run/secrets", dir)
+
+	defer func(dir string) { shmDir = dir }(shmDir)
+	shmDir = os.TempDir()
+	if !isTmpfs(shmDir) {
+		_, er = filesDir(&config{})
+		assert.Error(t, er)
+	}
+}
+
+func TestMaterialize(t *testing.T) {
+	base, er := ioutil.TempDir("", "vest-test-")
+	require.NoError(t, er)
+	defer os.RemoveAll(base)
+
+	secrets := environ.New()
+	secrets.Merge(map[string]string{"TLS_KEY": "key", "TLS_CERT": "cert", "DB_PASS": "hunter2"})
+	secrets.AddFile(environ.File{Var: "AWS_SHARED_CREDENTIALS_FILE", Path: filepath.Join(base, "aws"), Content: "[default]"})
+
+	vars, cleanup, er := materialize(&config{FileKeys: []string{"TLS_*"}, FilesDir: base}, secrets, nil)
+	require.NoError(t, er)
+
+	assert.Len(t, vars, 3)
+	dir := filepath.Dir(vars["TLS_KEY_FILE"])
+	fi, er := os.Stat(dir)
+	require.NoError(t, er)
+	assert.Equal(t, secretDirMode, fi.Mode().Perm())
+
+	for name, content := range map[string]string{"TLS_KEY_FILE": "key", "TLS_CERT_FILE": "cert", "AWS_SHARED_CREDENTIALS_FILE": "[default]"} {
+		assert.Equal(t, filepath.Join(dir, name), vars[name])
+		data, er := ioutil.ReadFile(vars[name])
+		require.NoError(t, er)
+		assert.Equal(t, content, string(data))
+
+		fi, er := os.Stat(vars[name])
+		require.NoError(t, er)
+		assert.Equal(t, secretFileMode, fi.Mode().Perm(), name)
+	}
+	assert.Equal(t, map[string]string{"DB_PASS": "hunter2"}, secrets.Map())
+
+	_, er = os.Stat(filepath.Join(base, "aws"))
+	assert.True(t, os.IsNotExist(er), "provider files of a supervised command stay in the private directory")
+
+	cleanup()
+	_, er = os.Stat(dir)
+	assert.True(t, os.IsNotExist(er))
+}
+
+func TestMaterializeExec(t *testing.T) {
+	base, er := ioutil.TempDir("", "vest-test-")
+	require.NoError(t, er)
+	defer os.RemoveAll(base)
+
+	path := filepath.Join(base, "gcp", "creds.json")
+	secrets := environ.New()
+	secrets.AddFile(environ.File{Var: "GOOGLE_CREDENTIALS_FILE", Path: path, Content: "{}"})
+
+	vars, cleanup, er := materialize(&config{}, secrets, nil)
+	require.NoError(t, er)
+	defer cleanup()
+
+	assert.Empty(t, vars)
+	assert.Equal(t, map[string]string{"GOOGLE_CREDENTIALS_FILE": path}, secrets.Map())
+	data, er := ioutil.ReadFile(path)
+	require.NoError(t, er)
+	assert.Equal(t, "{}", string(data))
+}
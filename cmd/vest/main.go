@@ -5,13 +5,15 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
-	"syscall"
+	"strings"
+	"time"
 
+	"github.com/lumoslabs/vestibule/pkg/environ/providers/bundle"
 	"github.com/lumoslabs/vestibule/pkg/environ/providers/dotenv"
 	"github.com/lumoslabs/vestibule/pkg/environ/providers/ejson"
 	"github.com/lumoslabs/vestibule/pkg/environ/providers/sops"
 	"github.com/lumoslabs/vestibule/pkg/environ/providers/vault"
-	"github.com/opencontainers/runc/libcontainer/user"
+	"gopkg.in/alecthomas/kingpin.v2"
 
 	env "github.com/caarlos0/env/v5"
 
@@ -28,6 +30,42 @@ Available providers: %v`, secretProviders),
 		"VEST_DEBUG":            "Enable debug logging.",
 		"VEST_VERBOSE":          "Enable verbose logging.",
 		"VEST_UPCASE_VAR_NAMES": "Upcase environment variable names gathered from secret providers. Default: true",
+		"VEST_PROTECTED_VARS": `Comma separated list of additional environment variable names (globs allowed) which secret providers
+may not override. e.g. VEST_PROTECTED_VARS=JAVA_HOME,GEM_*`,
+		"VEST_PROTECT_POLICY": `Comma separated list of policies applied when a secret provider sets a protected name. Policies are
+refuse, warn or allow, optionally scoped to a provider. Default: refuse
+e.g. VEST_PROTECT_POLICY=refuse,dotenv=warn`,
+		"VEST_STRICT":    "Exit with an error instead of running the command if any secret provider fails.",
+		"VEST_CLEAN_ENV": "Do not pass the environment of vest through to the command. Only gathered secrets, HOME and PATH are set.",
+		"VEST_DELIVER": `How secrets are delivered to the command, either env or fd. With fd, secrets are marshalled into a sealed
+memfd (or a pipe) inherited by the command at VEST_DELIVER_FD, which must be 3 or more, and are not set in its
+environment. Provider configuration such as VAULT_TOKEN is removed from its environment too. The command finds the
+descriptor in VEST_SECRETS_FD and its format in VEST_SECRETS_FORMAT. Default: env`,
+		"VEST_SUPERVISE": `Run the command as a child of vest instead of replacing vest with it. Signals are forwarded to the
+command and vest exits with its exit code.`,
+		"VEST_FILE_KEYS": `Comma separated list of secret names (globs allowed) to write to individual files instead of the
+environment. Files are written with mode 0400, owned by the user, to a private directory on tmpfs and removed
+when the command exits. The path of each file is set in <KEY>_FILE. Implies VEST_SUPERVISE. Credential files
+from providers, such as the vault AWS credentials file, go to the same directory whenever vest supervises the command.
+e.g. VEST_FILE_KEYS=TLS_*,GOOGLE_APPLICATION_CREDENTIALS_JSON`,
+		"VEST_FILES_DIR": "Directory to create the private secret files directory in. Default: /dev/shm, which must be a tmpfs",
+		"VEST_TEMPLATE_ARGS": `Render the arguments of the command as Go templates against the gathered secrets before running it.
+Rendered arguments are redacted in logs. e.g. vest --template-args -- psql '--password={{ .DB_PASSWORD }}'`,
+		"VEST_TEMPLATE_STRICT": "Fail instead of rendering an empty string when a templated argument references an undefined secret.",
+		"VEST_GRACE_PERIOD":    "How long run-all waits for processes to stop after a required process exits before killing them. Default: 10s",
+		"VEST_SERVE": `Serve secrets to the command over HTTP on a Unix socket instead of setting them in its environment.
+The socket is owned by the user and its path is set in VEST_SOCKET. Endpoints: GET /v1/secrets,
+GET /v1/secrets/{key} and GET /v1/health. Only the user and root may connect. Implies VEST_SUPERVISE.`,
+		"VEST_SERVE_SOCKET": "Path of the secret server socket. Default: a private directory in VEST_FILES_DIR, else the temp directory",
+		"VEST_SERVE_REFRESH": `How often the secret server refreshes secrets from the providers, 0 to disable. With VEST_USER providers
+run as the user. While refreshing fails the last secrets are served and /v1/health returns 503. Default: 5m`,
+		"VEST_REDACT": `Pipe the output of the command through vest, replacing every occurrence of a secret value with ***.
+Implies VEST_SUPERVISE. The output of the command is no longer a terminal.`,
+		"VEST_REDACT_MIN_LENGTH":  "Secret values shorter than this are not redacted. Default: 8",
+		"VEST_REDACT_MIN_ENTROPY": "Secret values with less entropy than this, in bits per character, are not redacted. Default: 0",
+		"VEST_PROFILE":            "Name of the profile shown in the prompt of vest shell. Default: the providers joined with +",
+		"VEST_DELIVER_FD":         "File descriptor number secrets are delivered at when VEST_DELIVER=fd. It must not already be open when vest starts. Default: 3",
+		"VEST_DELIVER_FORMAT":     fmt.Sprintf("Format of secrets delivered when VEST_DELIVER=fd. Default: json. Available formats: %v", environ.Marshallers()),
 	}
 
 	secretProviders = []string{
@@ -35,44 +73,113 @@ Available providers: %v`, secretProviders),
 		ejson.Name,
 		vault.Name,
 		sops.Name,
+		bundle.Name,
 	}
-	secretProviderEnvVars = []map[string]string{
-		envVars,
+	// providerEnvVars configure the secret providers, and may hold credentials such as VAULT_TOKEN
+	providerEnvVars = []map[string]string{
 		vault.EnvVars,
 		dotenv.EnvVars,
 		ejson.EnvVars,
 		sops.EnvVars,
+		bundle.EnvVars,
 	}
+	secretProviderEnvVars = append([]map[string]string{envVars}, providerEnvVars...)
 )
 
 type config struct {
-	User       string   `env:"VEST_USER"`
-	Providers  []string `env:"VEST_PROVIDERS" envSeparator:"," envDefault:"vault"`
-	Debug      bool     `env:"VEST_DEBUG"`
-	Verbose    bool     `env:"VEST_VERBOSE"`
-	UpcaseVars bool     `env:"VEST_UPCASE_VAR_NAMES" envDefault:"true"`
+	User             string        `env:"VEST_USER"`
+	Providers        []string      `env:"VEST_PROVIDERS" envSeparator:"," envDefault:"vault"`
+	Debug            bool          `env:"VEST_DEBUG"`
+	Verbose          bool          `env:"VEST_VERBOSE"`
+	UpcaseVars       bool          `env:"VEST_UPCASE_VAR_NAMES" envDefault:"true"`
+	Protected        []string      `env:"VEST_PROTECTED_VARS" envSeparator:","`
+	Policies         []string      `env:"VEST_PROTECT_POLICY" envSeparator:"," envDefault:"refuse"`
+	Strict           bool          `env:"VEST_STRICT"`
+	CleanEnv         bool          `env:"VEST_CLEAN_ENV"`
+	Deliver          string        `env:"VEST_DELIVER" envDefault:"env"`
+	SecretsFd        int           `env:"VEST_DELIVER_FD" envDefault:"3"`
+	SecretsFormat    string        `env:"VEST_DELIVER_FORMAT" envDefault:"json"`
+	Supervise        bool          `env:"VEST_SUPERVISE"`
+	FileKeys         []string      `env:"VEST_FILE_KEYS" envSeparator:","`
+	FilesDir         string        `env:"VEST_FILES_DIR"`
+	TemplateArgs     bool          `env:"VEST_TEMPLATE_ARGS"`
+	TemplateStrict   bool          `env:"VEST_TEMPLATE_STRICT"`
+	GracePeriod      time.Duration `env:"VEST_GRACE_PERIOD" envDefault:"10s"`
+	Serve            bool          `env:"VEST_SERVE"`
+	Socket           string        `env:"VEST_SERVE_SOCKET"`
+	RefreshInterval  time.Duration `env:"VEST_SERVE_REFRESH" envDefault:"5m"`
+	Redact           bool          `env:"VEST_REDACT"`
+	RedactMinLength  int           `env:"VEST_REDACT_MIN_LENGTH" envDefault:"8"`
+	RedactMinEntropy float64       `env:"VEST_REDACT_MIN_ENTROPY" envDefault:"0"`
+	Profile          string        `env:"VEST_PROFILE"`
+	Procfile         string
+	Explain          bool
+	Command          []string
+
+	// providerEnv restores the provider configuration consumed by the first populate
+	providerEnv environ.ProviderEnv
 }
 
 func init() {
 	runtime.LockOSThread()
 }
 
-func main() {
-	logLevel := "disabled"
+// newApp returns the vest commandline application. Flags default to the values already parsed from the environment
+// into the config and write back into it.
+func newApp(conf *config) (*kingpin.Application, map[string]*kingpin.CmdClause) {
+	app := kingpin.New("vest", usage()).Interspersed(false)
+	app.Author(author)
+	app.Version(appVersion())
+	app.HelpFlag.Short('h')
 
-	if len(os.Args) >= 2 {
-		switch os.Args[1] {
-		case "--help", "-h", "-?":
-			fmt.Println(usage())
-			os.Exit(0)
-		case "--version", "-v":
-			fmt.Println(appVersion())
-			os.Exit(0)
-		}
+	app.Flag("debug", "Debug output").Short('D').BoolVar(&conf.Debug)
+	app.Flag("verbose", "Verbose output").Short('v').BoolVar(&conf.Verbose)
+	app.Flag("user", "The user [and group] to run the command as. e.g. --user=user[:group]").Short('u').Default(conf.User).StringVar(&conf.User)
+	app.Flag("provider", fmt.Sprintf("Secret provider. Can be used multiple times. Available providers: %v", secretProviders)).Short('p').Default(conf.Providers...).StringsVar(&conf.Providers)
+	app.Flag("upcase-var-names", "Upcase environment variable names gathered from secret providers.").Default(fmt.Sprint(conf.UpcaseVars)).BoolVar(&conf.UpcaseVars)
+	app.Flag("protect", "Additional environment variable name (globs allowed) which secret providers may not override. Can be used multiple times.").Default(conf.Protected...).StringsVar(&conf.Protected)
+	app.Flag("protect-policy", "Policy applied when a secret provider sets a protected name: refuse, warn or allow, optionally scoped as provider=policy. Can be used multiple times.").Default(conf.Policies...).StringsVar(&conf.Policies)
+	app.Flag("strict", "Exit with an error instead of running the command if any secret provider fails.").BoolVar(&conf.Strict)
+	app.Flag("clean-env", "Do not pass the environment of vest through to the command. Only gathered secrets, HOME and PATH are set.").BoolVar(&conf.CleanEnv)
+	app.Flag("deliver", "How secrets are delivered to the command: env, or fd to marshal them into an inherited file descriptor.").Default(conf.Deliver).EnumVar(&conf.Deliver, deliverEnv, deliverFd)
+	app.Flag("deliver-fd", "File descriptor number secrets are delivered at with --deliver=fd. It must not already be open when vest starts.").Default(fmt.Sprint(conf.SecretsFd)).IntVar(&conf.SecretsFd)
+	app.Flag("deliver-format", fmt.Sprintf("Format of secrets delivered with --deliver=fd. Available formats: %v", environ.Marshallers())).Default(conf.SecretsFormat).HintOptions(environ.Marshallers()...).EnumVar(&conf.SecretsFormat, environ.Marshallers()...)
+	app.Flag("supervise", "Run the command as a child of vest, forwarding signals to it, instead of replacing vest with it.").BoolVar(&conf.Supervise)
+	app.Flag("file-key", "Secret name (globs allowed) to write to its own file, setting <KEY>_FILE instead of <KEY>. Files are removed when the command exits. Implies --supervise. Can be used multiple times.").Default(conf.FileKeys...).StringsVar(&conf.FileKeys)
+	app.Flag("files-dir", "Directory to create the private secret files directory in. Defaults to /dev/shm, which must be a tmpfs.").Default(conf.FilesDir).StringVar(&conf.FilesDir)
+	app.Flag("template-args", "Render the arguments of the command as Go templates against the gathered secrets e.g. '--password={{ .DB_PASSWORD }}'.").BoolVar(&conf.TemplateArgs)
+	app.Flag("template-strict", "Fail when a templated argument references an undefined secret.").BoolVar(&conf.TemplateStrict)
+	app.Flag("serve", "Serve secrets over HTTP on a Unix socket, advertised in VEST_SOCKET, instead of setting them in the environment. Implies --supervise.").BoolVar(&conf.Serve)
+	app.Flag("serve-socket", "Path of the secret server socket. Defaults to a private directory in --files-dir, else the temp directory.").Default(conf.Socket).StringVar(&conf.Socket)
+	app.Flag("serve-refresh", "How often the secret server refreshes secrets from the providers, as the user with --user, 0 to disable. /v1/health returns 503 while refreshing fails.").Default(conf.RefreshInterval.String()).DurationVar(&conf.RefreshInterval)
+	app.Flag("redact", "Replace every occurrence of a secret value in the output of the command with ***. Implies --supervise.").BoolVar(&conf.Redact)
+	app.Flag("redact-min-length", "Secret values shorter than this are not redacted.").Default(fmt.Sprint(conf.RedactMinLength)).IntVar(&conf.RedactMinLength)
+	app.Flag("redact-min-entropy", "Secret values with less entropy than this, in bits per character, are not redacted.").Default(fmt.Sprint(conf.RedactMinEntropy)).Float64Var(&conf.RedactMinEntropy)
+	app.Flag("explain", "Print what would be run, as whom and which variables would be injected (names only), then exit.").BoolVar(&conf.Explain)
+
+	cmds := map[string]*kingpin.CmdClause{
+		"exec":    app.Command("exec", "Run a command with secrets in its environment. Use -- to separate vest flags from the command.").Default(),
+		"env":     app.Command("env", "Print the environment the command would be run with."),
+		"shell":   app.Command("shell", "Start an interactive $SHELL with secrets loaded and the profile shown in its prompt. Credential files written by providers are removed when the shell exits."),
+		"run-all": app.Command("run-all", "Run every process from a Procfile or YAML file, each with its own user and scope of secrets. --file-key, --template-args and --redact apply to every process."),
+		"version": app.Command("version", "Show application version."),
 	}
+	cmds["exec"].Arg("command", "Command to run, followed by its arguments").StringsVar(&conf.Command)
+	cmds["shell"].Flag("profile", "Name of the profile shown in the prompt. Defaults to the providers joined with +.").Default(conf.Profile).StringVar(&conf.Profile)
+	cmds["run-all"].Flag("grace-period", "How long to wait for processes to stop after a required process exits before killing them.").Default(conf.GracePeriod.String()).DurationVar(&conf.GracePeriod)
+	cmds["run-all"].Arg("file", "Procfile of `name: command` lines, or a YAML (.yml, .yaml) list of processes with name, command, user, secrets, providers, files and required.").Default("Procfile").StringVar(&conf.Procfile)
+
+	return app, cmds
+}
 
+func main() {
 	conf := new(config)
 	env.Parse(conf)
+	app, cmds := newApp(conf)
+
+	cmd := kingpin.MustParse(parseArgs(conf, app, cmds, os.Args[1:]))
+
+	logLevel := "disabled"
 	if conf.Verbose {
 		logLevel = "info"
 	}
@@ -86,80 +193,137 @@ func main() {
 		log.Debugf("Config: %#v", conf)
 	}
 
-	secrets := environ.New()
-	secrets.UpcaseKeys = conf.UpcaseVars
-	secrets.Populate(conf.Providers)
-
-	if name, er := exec.LookPath(os.Args[1]); er != nil {
-		os.Unsetenv("HOME")
-		secrets.Delete("HOME")
+	if cmd == "version" {
+		fmt.Println(appVersion())
+		return
+	}
 
-		u := os.Args[1]
-		if conf.User != "" {
-			u = conf.User
+	if conf.Deliver == deliverFd && conf.SecretsFd >= 3 {
+		if er := reserveFd(conf.SecretsFd); er != nil {
+			log.Debugf("Failed to reserve secrets fd. fd=%d err=%v", conf.SecretsFd, er)
 		}
+	}
 
-		usr, er := getUser(u)
-		if er != nil {
-			log.Infof("error: unable to find %q: %v", u, er)
+	secrets, er := populate(conf)
+	if er != nil {
+		log.Infof("error: %v", er)
+		os.Exit(1)
+	}
+
+	switch cmd {
+	case "shell":
+		if er := runShell(conf, secrets); er != nil {
+			if ee, ok := er.(*exitError); ok {
+				os.Exit(ee.code)
+			}
+			log.Infof("error: %v", er)
 			os.Exit(1)
 		}
-
-		name, er = exec.LookPath(os.Args[2])
+	case "run-all":
+		procs, er := loadProcesses(conf.Procfile)
 		if er != nil {
 			log.Infof("error: %v", er)
 			os.Exit(1)
 		}
-
-		if er := SetupUser(usr); er != nil {
-			log.Infof("error: failed switching to %q: %v", u, er)
+		if er := runAll(conf, secrets, procs); er != nil {
+			if ee, ok := er.(*exitError); ok {
+				os.Exit(ee.code)
+			}
+			log.Infof("error: %v", er)
 			os.Exit(1)
 		}
-
-		secrets.SafeAppend(os.Environ())
-		if er = syscall.Exec(name, os.Args[2:], secrets.Slice()); er != nil {
-			log.Infof("error: exec failed: %v", er)
-			os.Exit(1)
+	case "env":
+		// env has no side effects, so provider files are not written, only their variables shown
+		for _, f := range secrets.Files() {
+			secrets.SafeMerge(map[string]string{f.Var: f.Path})
+		}
+		for _, item := range childEnv(conf, secrets, nil) {
+			fmt.Println(item)
+		}
+	case "exec":
+		if len(conf.Command) == 0 {
+			app.FatalUsage("a command is required")
 		}
-	} else {
-		if conf.User != "" {
-			os.Unsetenv("HOME")
-			secrets.Delete("HOME")
-
-			usr, er := getUser(conf.User)
-			if er != nil {
-				log.Infof("error: unable to find %q: %v", conf.User, er)
-				os.Exit(1)
+		if er := execCommand(conf, secrets, nil); er != nil {
+			if ee, ok := er.(*exitError); ok {
+				os.Exit(ee.code)
 			}
+			log.Infof("error: %v", er)
+			os.Exit(1)
+		}
+	}
+}
+
+// parseArgs parses the arguments into the config and returns the vest command to run. Gosu compatible arguments are
+// not parsed by kingpin, so the config keeps what was parsed from the environment.
+func parseArgs(conf *config, app *kingpin.Application, cmds map[string]*kingpin.CmdClause, args []string) (string, error) {
+	if legacy(args, app, cmds) {
+		parseLegacy(conf, args)
+		return "exec", nil
+	}
+
+	// repeatable flags append to their target and already default to the environment values, so start them empty
+	conf.Providers, conf.Protected, conf.Policies, conf.FileKeys = nil, nil, nil, nil
+	return app.Parse(separated(args, cmds))
+}
 
-			if er := SetupUser(usr); er != nil {
-				log.Infof("error: failed switching to %q: %v", conf.User, er)
-				os.Exit(1)
+// legacy returns true if the arguments are in the gosu compatible form `vest user-spec command [args]` or
+// `vest command [args]`, i.e. the first argument is neither a flag nor a vest command. A vest command which takes no
+// arguments does not shadow a command of the same name in PATH: `vest env FOO=1 app` runs env from PATH, while
+// `vest env` prints the environment.
+func legacy(args []string, app *kingpin.Application, cmds map[string]*kingpin.CmdClause) bool {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return false
+	}
+	cmd, ok := cmds[args[0]]
+	if !ok {
+		return args[0] != "help"
+	}
+	if len(args) == 1 || len(cmd.Model().Args) > 0 || isFlag(args[1], app.Model().Flags, cmd.Model().Flags) {
+		return false
+	}
+	_, er := exec.LookPath(args[0])
+	return er == nil
+}
+
+// isFlag returns true if arg names one of the flags
+func isFlag(arg string, flags ...[]*kingpin.FlagModel) bool {
+	name := strings.SplitN(arg, "=", 2)[0]
+	for _, fs := range flags {
+		for _, f := range fs {
+			if name == "--"+f.Name || name == "--no-"+f.Name || (f.Short != 0 && name == "-"+string(f.Short)) {
+				return true
 			}
 		}
+	}
+	return false
+}
 
-		secrets.SafeAppend(os.Environ())
-		if er = syscall.Exec(name, os.Args[1:], secrets.Slice()); er != nil {
-			log.Infof("error: exec failed: %v", er)
-			os.Exit(1)
+// separated returns the arguments with exec inserted before the first --, unless a vest command comes before it, so
+// that whatever follows -- is always the command to run, even when it shares a name with a vest command
+func separated(args []string, cmds map[string]*kingpin.CmdClause) []string {
+	for i, arg := range args {
+		if arg == "--" {
+			out := append([]string{}, args[:i]...)
+			return append(append(out, "exec"), args[i:]...)
+		}
+		if _, ok := cmds[arg]; ok {
+			return args
 		}
 	}
+	return args
 }
 
-func getUser(usr string) (*user.ExecUser, error) {
-	defaultExecUser := user.ExecUser{
-		Uid:  syscall.Getuid(),
-		Gid:  syscall.Getgid(),
-		Home: "/",
-	}
-	passwdPath, err := user.GetPasswdPath()
-	if err != nil {
-		return nil, err
-	}
-	groupPath, err := user.GetGroupPath()
-	if err != nil {
-		return nil, err
+// parseLegacy sets the user and command from gosu compatible arguments. If the first argument is found in PATH
+// it is the command, otherwise it is the user-spec. VEST_USER overrides the user-spec if set.
+func parseLegacy(conf *config, args []string) {
+	if _, er := exec.LookPath(args[0]); er == nil {
+		conf.Command = args
+		return
 	}
 
-	return user.GetExecUserPath(usr, &defaultExecUser, passwdPath, groupPath)
+	if conf.User == "" {
+		conf.User = args[0]
+	}
+	conf.Command = args[1:]
 }
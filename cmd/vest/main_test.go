@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	env "github.com/caarlos0/env/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLegacy(t *testing.T) {
+	app, cmds := newApp(new(config))
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{nil, false},
+		{[]string{"-p", "dotenv", "--", "printenv"}, false},
+		{[]string{"--", "printenv"}, false},
+		{[]string{"printenv", "FOO"}, true},
+		{[]string{"nobody", "printenv", "FOO"}, true},
+		{[]string{"env"}, false},
+		{[]string{"env", "FOO=1", "printenv"}, true},
+		{[]string{"env", "-v"}, false},
+		{[]string{"exec", "printenv"}, false},
+		{[]string{"run-all", "Procfile"}, false},
+		{[]string{"help"}, false},
+	}
+	for _, tt := range tests {
+		assert.Equalf(t, tt.want, legacy(tt.args, app, cmds), "%q", tt.args)
+	}
+}
+
+func TestParseLegacy(t *testing.T) {
+	conf := new(config)
+	parseLegacy(conf, []string{"printenv", "FOO"})
+	assert.Equal(t, "", conf.User)
+	assert.Equal(t, []string{"printenv", "FOO"}, conf.Command)
+
+	conf = new(config)
+	parseLegacy(conf, []string{"nobody:nogroup", "printenv", "FOO"})
+	assert.Equal(t, "nobody:nogroup", conf.User)
+	assert.Equal(t, []string{"printenv", "FOO"}, conf.Command)
+
+	conf = &config{User: "app"}
+	parseLegacy(conf, []string{"nobody", "printenv"})
+	assert.Equal(t, "app", conf.User, "VEST_USER overrides the user-spec")
+	assert.Equal(t, []string{"printenv"}, conf.Command)
+}
+
+func TestSeparated(t *testing.T) {
+	_, cmds := newApp(new(config))
+	tests := []struct {
+		args, want []string
+	}{
+		{[]string{"-p", "dotenv", "--", "env"}, []string{"-p", "dotenv", "exec", "--", "env"}},
+		{[]string{"--", "printenv", "--", "x"}, []string{"exec", "--", "printenv", "--", "x"}},
+		{[]string{"run-all", "--", "Procfile"}, []string{"run-all", "--", "Procfile"}},
+		{[]string{"-v", "env"}, []string{"-v", "env"}},
+	}
+	for _, tt := range tests {
+		assert.Equalf(t, tt.want, separated(tt.args, cmds), "%q", tt.args)
+	}
+}
+
+func TestIsFlag(t *testing.T) {
+	app, cmds := newApp(new(config))
+	flags := app.Model().Flags
+	for _, arg := range []string{"--verbose", "-v", "--provider=dotenv", "--no-upcase-var-names", "-p"} {
+		assert.Truef(t, isFlag(arg, flags), arg)
+	}
+	for _, arg := range []string{"--nope", "-x", "verbose", "FOO=1"} {
+		assert.Falsef(t, isFlag(arg, flags), arg)
+	}
+	assert.False(t, isFlag("--profile", flags))
+	assert.True(t, isFlag("--profile", flags, cmds["shell"].Model().Flags))
+}
+
+func TestParseArgsEnv(t *testing.T) {
+	vars := map[string]string{
+		"VEST_PROVIDERS":      "dotenv,ejson",
+		"VEST_PROTECTED_VARS": "APP_*,SECRET",
+		"VEST_PROTECT_POLICY": "warn",
+		"VEST_FILE_KEYS":      "TLS_*",
+	}
+	for k, v := range vars {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+	parse := func(args ...string) (*config, string) {
+		conf := new(config)
+		require.NoError(t, env.Parse(conf))
+		app, cmds := newApp(conf)
+		cmd, er := parseArgs(conf, app, cmds, args)
+		require.NoErrorf(t, er, "%q", args)
+		return conf, cmd
+	}
+	check := func(conf *config, form string) {
+		assert.Equalf(t, []string{"dotenv", "ejson"}, conf.Providers, form)
+		assert.Equalf(t, []string{"APP_*", "SECRET"}, conf.Protected, form)
+		assert.Equalf(t, []string{"warn"}, conf.Policies, form)
+		assert.Equalf(t, []string{"TLS_*"}, conf.FileKeys, form)
+	}
+
+	conf, cmd := parse("printenv", "FOO")
+	assert.Equal(t, "exec", cmd)
+	assert.Equal(t, []string{"printenv", "FOO"}, conf.Command)
+	check(conf, "legacy")
+
+	conf, cmd = parse("--", "printenv", "FOO")
+	assert.Equal(t, "exec", cmd)
+	assert.Equal(t, []string{"printenv", "FOO"}, conf.Command)
+	check(conf, "separated")
+
+	conf, _ = parse("-p", "vault", "--protect", "X", "--", "printenv")
+	assert.Equal(t, []string{"vault"}, conf.Providers, "flags replace the environment values")
+	assert.Equal(t, []string{"X"}, conf.Protected)
+	assert.Equal(t, []string{"warn"}, conf.Policies)
+
+	os.Unsetenv("VEST_PROVIDERS")
+	conf, _ = parse("printenv", "FOO")
+	assert.Equal(t, []string{"vault"}, conf.Providers, "the default provider runs in the legacy form")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	solLocal      = 0
+	localPeerCred = 1
+)
+
+// xucred is struct xucred from sys/ucred.h
+type xucred struct {
+	Version uint32
+	UID     uint32
+	Ngroups int16
+	Groups  [16]uint32
+}
+
+// peerUID returns the uid of the process on the other end of the connection from LOCAL_PEERCRED
+func peerUID(c *net.UnixConn) (int, error) {
+	raw, er := c.SyscallConn()
+	if er != nil {
+		return -1, er
+	}
+
+	var (
+		cred xucred
+		cer  error
+	)
+	if er := raw.Control(func(fd uintptr) {
+		size := uint32(unsafe.Sizeof(cred))
+		_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, solLocal, localPeerCred, uintptr(unsafe.Pointer(&cred)), uintptr(unsafe.Pointer(&size)), 0)
+		if errno != 0 {
+			cer = errno
+		}
+	}); er != nil {
+		return -1, er
+	}
+	if cer != nil {
+		return -1, cer
+	}
+	return int(cred.UID), nil
+}
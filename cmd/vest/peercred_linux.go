@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerUID returns the uid of the process on the other end of the connection from SO_PEERCRED
+func peerUID(c *net.UnixConn) (int, error) {
+	raw, er := c.SyscallConn()
+	if er != nil {
+		return -1, er
+	}
+
+	var (
+		cred *unix.Ucred
+		cer  error
+	)
+	if er := raw.Control(func(fd uintptr) {
+		cred, cer = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); er != nil {
+		return -1, er
+	}
+	if cer != nil {
+		return -1, cer
+	}
+	return int(cred.Uid), nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"sort"
+	"sync"
+)
+
+// redactedValue replaces secret values in the output of the command
+const redactedValue = "***"
+
+// redactor replaces every exact occurrence of a secret value in the stream written to it with ***. Bytes which could
+// not be the start of a secret are written straight through, so both line buffered and binary output pass without
+// waiting for newlines. Only a trailing partial match is held back until more is written or the redactor is flushed.
+type redactor struct {
+	sync.Mutex
+
+	w          io.Writer
+	secrets    [][]byte
+	seen       map[string]bool
+	buf        []byte
+	minLength  int
+	minEntropy float64
+}
+
+// newRedactor returns a redactor writing to w which redacts the values at least minLength long with at least
+// minEntropy bits of entropy per character
+func newRedactor(w io.Writer, values []string, minLength int, minEntropy float64) *redactor {
+	r := &redactor{w: w, seen: make(map[string]bool), minLength: minLength, minEntropy: minEntropy}
+	r.add(values)
+	return r
+}
+
+// Add redacts the values from now on too, such as secrets refreshed while the command runs
+func (r *redactor) Add(values []string) {
+	r.Lock()
+	defer r.Unlock()
+	r.add(values)
+}
+
+func (r *redactor) add(values []string) {
+	for _, v := range values {
+		if r.seen[v] || len(v) == 0 || len(v) < r.minLength || entropy(v) < r.minEntropy {
+			continue
+		}
+		r.seen[v] = true
+		r.secrets = append(r.secrets, []byte(v))
+	}
+	// longest first so a secret containing another is redacted whole
+	sort.SliceStable(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+}
+
+func (r *redactor) Write(p []byte) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if len(r.secrets) == 0 && len(r.buf) == 0 {
+		return r.w.Write(p)
+	}
+
+	out, held := r.redact(append(r.buf, p...), false)
+	if len(out) > 0 {
+		if _, er := r.w.Write(out); er != nil {
+			return 0, er
+		}
+	}
+	r.buf = append(r.buf[:0], held...)
+	return len(p), nil
+}
+
+// Flush writes any held back bytes
+func (r *redactor) Flush() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if len(r.buf) == 0 {
+		return nil
+	}
+	out, _ := r.redact(r.buf, true)
+	r.buf = r.buf[:0]
+	_, er := r.w.Write(out)
+	return er
+}
+
+// redact replaces every complete secret in b. Unless final, it stops at the first byte from which the rest of b could
+// be the start of a secret, even one longer than a secret matching there, and returns the rest to hold back.
+func (r *redactor) redact(b []byte, final bool) ([]byte, []byte) {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); {
+		if !final && r.partial(b[i:]) {
+			return out, b[i:]
+		}
+
+		matched := false
+		for _, s := range r.secrets {
+			if bytes.HasPrefix(b[i:], s) {
+				out = append(out, redactedValue...)
+				i += len(s)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			out = append(out, b[i])
+			i++
+		}
+	}
+	return out, nil
+}
+
+// partial returns true if b is the start of a secret but not all of it
+func (r *redactor) partial(b []byte) bool {
+	for _, s := range r.secrets {
+		if len(b) < len(s) && bytes.HasPrefix(s, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// entropy returns the Shannon entropy of s in bits per character
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	total := 0
+	for _, c := range s {
+		counts[c]++
+		total++
+	}
+
+	var h float64
+	for _, n := range counts {
+		p := float64(n) / float64(total)
+		h -= p * math.Log2(p)
+	}
+	return h
+}
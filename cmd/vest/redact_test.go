@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactor(t *testing.T) {
+	tt := []struct {
+		name      string
+		secrets   []string
+		minLength int
+		writes    []string
+		out       string
+	}{
+		{"whole", []string{"hunter22"}, 0, []string{"pass=hunter22\n"}, "pass=***\n"},
+		{"split across writes", []string{"hunter22"}, 0, []string{"pass=hun", "ter22\n"}, "pass=***\n"},
+		{"split byte by byte", []string{"hunter22"}, 0, []string{"h", "u", "n", "t", "e", "r", "2", "2", "!"}, "***!"},
+		{"false start", []string{"hunter22"}, 0, []string{"hunt", "ing hunter22"}, "hunting ***"},
+		{"repeated prefix", []string{"aaab1234"}, 0, []string{"aaaa", "ab1234"}, "aa***"},
+		{"contained", []string{"token", "token-secret"}, 0, []string{"token-secret token"}, "*** ***"},
+		{"longer secret split after a shorter one", []string{"cdefgh", "abcdefghij"}, 0, []string{"abcdefgh", "ij."}, "***."},
+		{"shorter secret when the longer one does not follow", []string{"cdefgh", "abcdefghij"}, 0, []string{"abcdefgh", "xx"}, "ab***xx"},
+		{"overlapping", []string{"abcdef12", "ef12ghij"}, 0, []string{"abcdef12ghij"}, "***ghij"},
+		{"below min length", []string{"short"}, 8, []string{"short"}, "short"},
+		{"no secrets", nil, 0, []string{"plain"}, "plain"},
+	}
+
+	for _, test := range tt {
+		var buf bytes.Buffer
+		r := newRedactor(&buf, test.secrets, test.minLength, 0)
+		for _, w := range test.writes {
+			n, er := r.Write([]byte(w))
+			assert.NoErrorf(t, er, test.name)
+			assert.Equalf(t, len(w), n, test.name)
+		}
+		assert.NoErrorf(t, r.Flush(), test.name)
+		assert.Equalf(t, test.out, buf.String(), test.name)
+	}
+}
+
+func TestRedactorFlush(t *testing.T) {
+	var buf bytes.Buffer
+	r := newRedactor(&buf, []string{"hunter22"}, 0, 0)
+
+	r.Write([]byte("user=admin pass=hunt"))
+	assert.Equal(t, "user=admin pass=", buf.String(), "a possible secret is held back")
+
+	r.Write([]byte("er2"))
+	assert.Equal(t, "user=admin pass=", buf.String())
+
+	assert.NoError(t, r.Flush())
+	assert.Equal(t, "user=admin pass=hunter2", buf.String(), "flush writes what never became a secret")
+	assert.NoError(t, r.Flush())
+	assert.Equal(t, "user=admin pass=hunter2", buf.String())
+}
+
+func TestRedactorAdd(t *testing.T) {
+	var buf bytes.Buffer
+	r := newRedactor(&buf, []string{"old-secret"}, 8, 0)
+
+	r.Write([]byte("old-secret new-secret\n"))
+	r.Add([]string{"new-secret", "tiny"})
+	r.Write([]byte("old-secret new-secret tiny\n"))
+	r.Flush()
+	assert.Equal(t, "*** new-secret\n*** *** tiny\n", buf.String())
+}
+
+func TestEntropyThreshold(t *testing.T) {
+	var buf bytes.Buffer
+	r := newRedactor(&buf, []string{"aaaaaaaaaa", "k8Zq2xLp0w"}, 0, 2)
+	r.Write([]byte("aaaaaaaaaa k8Zq2xLp0w"))
+	r.Flush()
+	assert.Equal(t, "aaaaaaaaaa ***", buf.String())
+}
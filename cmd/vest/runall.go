@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/user"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+// process is a single command run by run-all
+type process struct {
+	Name      string      `yaml:"name"`
+	Command   procCommand `yaml:"command"`
+	User      string      `yaml:"user"`
+	Secrets   []string    `yaml:"secrets"`
+	Providers []string    `yaml:"providers"`
+	Files     []string    `yaml:"files"`
+	Required  *bool       `yaml:"required"`
+
+	cmd     *exec.Cmd
+	out     []flusher
+	cleanup func()
+}
+
+// flusher writes out anything it holds back
+type flusher interface {
+	Flush() error
+}
+
+// procCommand is a command and its arguments. A single string is run with /bin/sh -c.
+type procCommand []string
+
+// UnmarshalYAML accepts either a string or a list of strings
+func (pc *procCommand) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if er := unmarshal(&s); er == nil {
+		*pc = shellCommand(s)
+		return nil
+	}
+
+	var l []string
+	if er := unmarshal(&l); er != nil {
+		return er
+	}
+	*pc = l
+	return nil
+}
+
+func shellCommand(s string) procCommand {
+	return procCommand{"/bin/sh", "-c", s}
+}
+
+func (p *process) required() bool {
+	return p.Required == nil || *p.Required
+}
+
+// loadProcesses reads processes from a YAML file (by extension) or a Procfile
+func loadProcesses(path string) ([]*process, error) {
+	data, er := ioutil.ReadFile(path)
+	if er != nil {
+		return nil, er
+	}
+
+	var procs []*process
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		if er := yaml.Unmarshal(data, &procs); er != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, er)
+		}
+	default:
+		if procs, er = parseProcfile(data); er != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, er)
+		}
+	}
+
+	if len(procs) == 0 {
+		return nil, fmt.Errorf("no processes found in %s", path)
+	}
+	for i, p := range procs {
+		if p.Name == "" || len(p.Command) == 0 {
+			return nil, fmt.Errorf("process %d in %s needs a name and a command", i, path)
+		}
+	}
+	return procs, nil
+}
+
+// parseProcfile parses `name: command` lines. Blank lines and lines starting with # are ignored.
+func parseProcfile(data []byte) ([]*process, error) {
+	procs := make([]*process, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		bits := strings.SplitN(line, ":", 2)
+		if len(bits) != 2 || strings.TrimSpace(bits[0]) == "" || strings.TrimSpace(bits[1]) == "" {
+			return nil, fmt.Errorf("invalid line %d: %q", n, line)
+		}
+		procs = append(procs, &process{
+			Name:    strings.TrimSpace(bits[0]),
+			Command: shellCommand(strings.TrimSpace(bits[1])),
+		})
+	}
+	return procs, scanner.Err()
+}
+
+// scope returns a new Environ holding only the secrets the process may see
+func (p *process) scope(conf *config, secrets *environ.Environ) *environ.Environ {
+	sources := secrets.Sources()
+	scoped := make(map[string]string)
+	for k, v := range secrets.Map() {
+		if len(p.Secrets) > 0 && !environ.MatchAny(p.Secrets, k) {
+			continue
+		}
+		if len(p.Providers) > 0 && !contains(p.Providers, sources[k]) {
+			continue
+		}
+		scoped[k] = v
+	}
+
+	e := environ.New()
+	e.UpcaseKeys = conf.UpcaseVars
+	e.Merge(scoped)
+	return e
+}
+
+// runAll populates secrets once, then runs every process with its own scope of secrets. Output is prefixed with
+// the process name and signals are forwarded to every process. When a required process exits the rest are stopped.
+func runAll(conf *config, secrets *environ.Environ, procs []*process) error {
+	if conf.Deliver != deliverEnv || conf.Serve {
+		return fmt.Errorf("run-all only supports delivering secrets in the environment")
+	}
+
+	width := 0
+	for _, p := range procs {
+		if len(p.Name) > width {
+			width = len(p.Name)
+		}
+	}
+
+	if conf.Explain {
+		for _, p := range procs {
+			scoped := p.scope(conf, secrets)
+			var files []string
+			for _, f := range p.files(conf, secrets, scoped) {
+				files = append(files, f.Var)
+			}
+			fmt.Printf("%-*s  user=%q required=%t command=%q secrets=%v files=%v\n", width, p.Name, p.User, p.required(), []string(p.Command), sortedKeys(scoped.Map()), files)
+		}
+		return nil
+	}
+
+	defer func() {
+		for _, p := range procs {
+			if p.cleanup != nil {
+				p.cleanup()
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	for _, p := range procs {
+		cmd, er := p.command(conf, secrets, width, &mu)
+		if er != nil {
+			return fmt.Errorf("%s: %v", p.Name, er)
+		}
+		p.cmd = cmd
+	}
+
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals)
+	defer signal.Stop(signals)
+
+	type exit struct {
+		p    *process
+		code int
+	}
+	exits := make(chan exit, len(procs))
+	exited := make(map[*process]bool, len(procs))
+	running := 0
+	for _, p := range procs {
+		if er := p.cmd.Start(); er != nil {
+			// the processes already started are killed and reaped before their secret files are removed
+			exited[p] = true
+			stopAll(procs, exited, syscall.SIGKILL)
+			for ; running > 0; running-- {
+				<-exits
+			}
+			return fmt.Errorf("%s: %v", p.Name, er)
+		}
+		log.Debugf("Started process. name=%s pid=%d", p.Name, p.cmd.Process.Pid)
+		running++
+
+		go func(p *process) {
+			er := p.cmd.Wait()
+			for _, w := range p.out {
+				w.Flush()
+			}
+			exits <- exit{p, exitCode(p.cmd, er)}
+		}(p)
+	}
+
+	var (
+		code     int
+		stopping bool
+		kill     <-chan time.Time
+	)
+	for running > 0 {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGCHLD || sig == syscall.SIGURG {
+				continue
+			}
+			log.Debugf("Forwarding signal to processes. signal=%v", sig)
+			stopAll(procs, exited, sig)
+		case ex := <-exits:
+			running--
+			exited[ex.p] = true
+			log.Infof("Process exited. name=%s code=%d", ex.p.Name, ex.code)
+			if !ex.p.required() || stopping {
+				continue
+			}
+
+			code, stopping = ex.code, true
+			log.Infof("Required process exited, stopping all processes. name=%s grace=%v", ex.p.Name, conf.GracePeriod)
+			stopAll(procs, exited, syscall.SIGTERM)
+			kill = time.After(conf.GracePeriod)
+		case <-kill:
+			log.Infof("Grace period expired, killing remaining processes.")
+			stopAll(procs, exited, syscall.SIGKILL)
+		}
+	}
+
+	if code != 0 {
+		return &exitError{code}
+	}
+	return nil
+}
+
+// files returns the secret files of the process: a <KEY>_FILE for each scoped secret matching --file-key or the
+// files of the process, which are taken out of scoped, and the provider files its secrets allowlist permits
+func (p *process) files(conf *config, secrets, scoped *environ.Environ) []environ.File {
+	var files []environ.File
+	for _, f := range secrets.Files() {
+		if len(p.Secrets) == 0 || environ.MatchAny(p.Secrets, f.Var) {
+			files = append(files, f)
+		}
+	}
+	keys := append(append([]string{}, p.Files...), conf.FileKeys...)
+	for key, v := range scoped.Extract(keys...) {
+		files = append(files, environ.File{Var: key + fileEnvVarSuffix, Content: v})
+	}
+	return files
+}
+
+// command returns the exec.Cmd for the process, running as its user with its scope of secrets and files. Its
+// arguments are rendered with --template-args and its output redacted with --redact, as for a single command.
+func (p *process) command(conf *config, secrets *environ.Environ, width int, mu *sync.Mutex) (*exec.Cmd, error) {
+	name, er := exec.LookPath(p.Command[0])
+	if er != nil {
+		return nil, er
+	}
+
+	scoped := p.scope(conf, secrets)
+	values := make([]string, 0, scoped.Len())
+	for _, v := range scoped.Map() {
+		values = append(values, v)
+	}
+	// each process gets its own process group so signals reach anything it spawns
+	attr := &syscall.SysProcAttr{Setpgid: true}
+
+	uid, gid := syscall.Getuid(), syscall.Getgid()
+	spec := p.User
+	if spec == "" {
+		spec = conf.User
+	}
+	if spec != "" {
+		usr, er := getUser(spec)
+		if er != nil {
+			return nil, fmt.Errorf("unable to find %q: %v", spec, er)
+		}
+		scoped.Set("HOME", usr.Home)
+		attr.Credential = credential(usr)
+		uid, gid = usr.Uid, usr.Gid
+	}
+
+	files := p.files(conf, secrets, scoped)
+	for _, f := range files {
+		values = append(values, f.Content)
+	}
+	vars, cleanup, er := writePrivateFiles(conf, files, uid, gid)
+	if er != nil {
+		return nil, fmt.Errorf("failed to write secret files: %v", er)
+	}
+	p.cleanup = cleanup
+
+	args, er := commandArgs(conf, p.Command, scoped, vars)
+	if er != nil {
+		return nil, er
+	}
+
+	stdout := newPrefixWriter(os.Stdout, fmt.Sprintf("%-*s | ", width, p.Name), mu)
+	stderr := newPrefixWriter(os.Stderr, fmt.Sprintf("%-*s | ", width, p.Name), mu)
+	p.out = []flusher{stdout, stderr}
+
+	cmd := &exec.Cmd{
+		Path:        name,
+		Args:        args,
+		Env:         childEnv(conf, scoped, vars),
+		Stdout:      stdout,
+		Stderr:      stderr,
+		SysProcAttr: attr,
+	}
+	if conf.Redact {
+		rout := newRedactor(stdout, values, conf.RedactMinLength, conf.RedactMinEntropy)
+		rerr := newRedactor(stderr, values, conf.RedactMinLength, conf.RedactMinEntropy)
+		// redactors flush first so their held back bytes reach the prefix writers
+		p.out = []flusher{rout, rerr, stdout, stderr}
+		cmd.Stdout, cmd.Stderr = rout, rerr
+	}
+	return cmd, nil
+}
+
+func credential(usr *user.ExecUser) *syscall.Credential {
+	groups := make([]uint32, 0, len(usr.Sgids))
+	for _, g := range usr.Sgids {
+		groups = append(groups, uint32(g))
+	}
+	return &syscall.Credential{Uid: uint32(usr.Uid), Gid: uint32(usr.Gid), Groups: groups}
+}
+
+// stopAll sends the signal to the process group of every started process which has not exited
+func stopAll(procs []*process, exited map[*process]bool, sig os.Signal) {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return
+	}
+
+	for _, p := range procs {
+		if p.cmd.Process != nil && !exited[p] {
+			syscall.Kill(-p.cmd.Process.Pid, s)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixWriter writes each complete line to the underlying writer with a prefix. Writers sharing a mutex never
+// interleave their lines.
+type prefixWriter struct {
+	w      io.Writer
+	prefix []byte
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+func newPrefixWriter(w io.Writer, prefix string, mu *sync.Mutex) *prefixWriter {
+	return &prefixWriter{w: w, prefix: []byte(prefix), mu: mu}
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.buf = append(pw.buf, p...)
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if er := pw.writeLine(pw.buf[:i+1]); er != nil {
+			return 0, er
+		}
+		pw.buf = pw.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes any incomplete last line
+func (pw *prefixWriter) Flush() error {
+	if len(pw.buf) == 0 {
+		return nil
+	}
+	er := pw.writeLine(append(pw.buf, '\n'))
+	pw.buf = nil
+	return er
+}
+
+func (pw *prefixWriter) writeLine(line []byte) error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	if _, er := pw.w.Write(pw.prefix); er != nil {
+		return er
+	}
+	_, er := pw.w.Write(line)
+	return er
+}
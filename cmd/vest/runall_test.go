@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+)
+
+func TestParseProcfile(t *testing.T) {
+	tt := []struct {
+		in    string
+		procs map[string]procCommand
+		err   bool
+	}{
+		{"web: bundle exec puma\nworker: sidekiq -q default\n", map[string]procCommand{
+			"web":    shellCommand("bundle exec puma"),
+			"worker": shellCommand("sidekiq -q default"),
+		}, false},
+		{"# comment\n\n  web:   nginx -g 'daemon off;'  \n", map[string]procCommand{
+			"web": shellCommand("nginx -g 'daemon off;'"),
+		}, false},
+		{"web: echo a:b:c", map[string]procCommand{"web": shellCommand("echo a:b:c")}, false},
+		{"", map[string]procCommand{}, false},
+		{"web puma", nil, true},
+		{": puma", nil, true},
+		{"web:", nil, true},
+	}
+
+	for _, test := range tt {
+		procs, er := parseProcfile([]byte(test.in))
+		if test.err {
+			assert.Errorf(t, er, "%q", test.in)
+			continue
+		}
+		require.NoErrorf(t, er, "%q", test.in)
+
+		got := make(map[string]procCommand, len(procs))
+		for _, p := range procs {
+			got[p.Name] = p.Command
+		}
+		assert.Equalf(t, test.procs, got, "%q", test.in)
+	}
+}
+
+func TestLoadProcessesYAML(t *testing.T) {
+	dir, er := ioutil.TempDir("", "vest-test-")
+	require.NoError(t, er)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "procs.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+- name: web
+  command: [nginx, -g, daemon off;]
+  user: www-data
+  secrets: [TLS_*]
+  files: [TLS_KEY]
+- name: cron
+  command: crond -f
+  required: false
+`), 0644))
+
+	procs, er := loadProcesses(path)
+	require.NoError(t, er)
+	require.Len(t, procs, 2)
+	assert.Equal(t, procCommand{"nginx", "-g", "daemon off;"}, procs[0].Command)
+	assert.Equal(t, []string{"TLS_KEY"}, procs[0].Files)
+	assert.True(t, procs[0].required())
+	assert.Equal(t, shellCommand("crond -f"), procs[1].Command)
+	assert.False(t, procs[1].required())
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("- name: web\n"), 0644))
+	_, er = loadProcesses(path)
+	assert.Error(t, er)
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		mu  sync.Mutex
+	)
+	web := newPrefixWriter(&buf, "web    | ", &mu)
+	worker := newPrefixWriter(&buf, "worker | ", &mu)
+
+	web.Write([]byte("listen"))
+	worker.Write([]byte("started\nqueue="))
+	web.Write([]byte("ing on :80\n"))
+	worker.Write([]byte("default\n"))
+	web.Write([]byte("no new line"))
+	assert.Equal(t, "worker | started\nweb    | listening on :80\nworker | queue=default\n", buf.String())
+
+	assert.NoError(t, web.Flush())
+	assert.NoError(t, worker.Flush())
+	assert.Equal(t, "worker | started\nweb    | listening on :80\nworker | queue=default\nweb    | no new line\n", buf.String())
+}
+
+func TestRunAllStartFailure(t *testing.T) {
+	dir, er := ioutil.TempDir("", "vest-test-")
+	require.NoError(t, er)
+	defer os.RemoveAll(dir)
+
+	// executable, but not something the kernel can run
+	bad := filepath.Join(dir, "bad")
+	require.NoError(t, ioutil.WriteFile(bad, []byte("\x00\x01\x02"), 0755))
+
+	procs := []*process{
+		{Name: "sleep", Command: procCommand{"sleep", "30"}},
+		{Name: "bad", Command: procCommand{bad}},
+	}
+	er = runAll(&config{Deliver: deliverEnv}, environ.New(), procs)
+	assert.Error(t, er)
+
+	require.NotNil(t, procs[0].cmd.ProcessState, "started processes are reaped")
+	assert.False(t, procs[0].cmd.ProcessState.Success())
+}
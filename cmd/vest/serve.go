@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/opencontainers/runc/libcontainer/user"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+const (
+	// socketEnvVar advertises the path of the secret server socket to the command
+	socketEnvVar   = "VEST_SOCKET"
+	socketName     = "vest.sock"
+	socketFileMode = os.FileMode(0600)
+
+	secretsPath = "/v1/secrets"
+	healthPath  = "/v1/health"
+)
+
+type peerKey struct{}
+
+// secretServer serves secrets over HTTP on a Unix socket to callers running as an allowed uid
+type secretServer struct {
+	sync.RWMutex
+
+	secrets   map[string]string
+	refreshed time.Time
+	failed    error
+	notify    func([]string)
+	// files maps the variables of the secret files of the command to their paths, which refresh rewrites
+	files    map[string]string
+	uids     map[int]bool
+	listener net.Listener
+	server   *http.Server
+	path     string
+	dir      string
+	done     chan struct{}
+}
+
+// newSecretServer listens on the configured socket path, or a socket in a new private directory, owned by the user.
+// Only the user, root and vest itself may connect.
+func newSecretServer(conf *config, secrets *environ.Environ, usr *user.ExecUser) (*secretServer, error) {
+	uid, gid := syscall.Getuid(), syscall.Getgid()
+	if usr != nil {
+		uid, gid = usr.Uid, usr.Gid
+	}
+
+	s := &secretServer{
+		secrets:   secrets.Map(),
+		refreshed: time.Now(),
+		uids:      map[int]bool{0: true, uid: true, syscall.Getuid(): true},
+		path:      conf.Socket,
+		done:      make(chan struct{}),
+	}
+
+	if s.path == "" {
+		// the socket holds no secrets, so it may go anywhere
+		dir, er := ioutil.TempDir(conf.FilesDir, "vest-")
+		if er != nil {
+			return nil, er
+		}
+		s.dir, s.path = dir, filepath.Join(dir, socketName)
+		if er := os.Chmod(dir, secretDirMode); er != nil {
+			s.remove()
+			return nil, er
+		}
+		if er := os.Chown(dir, uid, gid); er != nil {
+			s.remove()
+			return nil, er
+		}
+	}
+
+	l, er := net.Listen("unix", s.path)
+	if er != nil {
+		s.remove()
+		return nil, er
+	}
+	s.listener = l
+	if er := os.Chmod(s.path, socketFileMode); er != nil {
+		s.Close()
+		return nil, er
+	}
+	if er := os.Chown(s.path, uid, gid); er != nil {
+		s.Close()
+		return nil, er
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(healthPath, s.health)
+	mux.HandleFunc(secretsPath, s.list)
+	mux.HandleFunc(secretsPath+"/", s.get)
+	s.server = &http.Server{
+		Handler:     s.authenticate(mux),
+		ConnContext: connContext,
+	}
+	return s, nil
+}
+
+// Serve serves requests until the server is closed, refreshing secrets from the providers every interval
+func (s *secretServer) Serve(conf *config, interval time.Duration) {
+	log.Infof("Serving secrets. socket=%s refresh=%v", s.path, interval)
+	if interval > 0 {
+		go s.refresh(conf, interval)
+	}
+	if er := s.server.Serve(s.listener); er != nil && er != http.ErrServerClosed {
+		log.Infof("Secret server failed. socket=%s err=%v", s.path, er)
+	}
+}
+
+// Close stops the server and removes the socket
+func (s *secretServer) Close() {
+	close(s.done)
+	if s.server != nil {
+		s.server.Close()
+	} else {
+		s.listener.Close()
+	}
+	s.remove()
+}
+
+func (s *secretServer) remove() {
+	if s.dir != "" {
+		os.RemoveAll(s.dir)
+		return
+	}
+	os.Remove(s.path)
+}
+
+// refresh repopulates secrets every interval. Secrets written to files are left out of the served secrets, and their
+// files in the private directory rewritten instead. vest has usually dropped privileges by now, so providers run as
+// the user: failures keep the last secrets, and health reports them until a refresh succeeds.
+func (s *secretServer) refresh(conf *config, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		var files []environ.File
+		secrets, er := gather(conf)
+		if er == nil {
+			files = secretFiles(conf.FileKeys, secrets)
+			er = s.rewriteFiles(files)
+		}
+		if er != nil {
+			log.Infof("Failed to refresh secrets, serving the last secrets. uid=%d err=%v", syscall.Getuid(), er)
+			s.Lock()
+			s.failed = er
+			s.Unlock()
+			continue
+		}
+
+		m := secrets.Map()
+		values := make([]string, 0, len(m))
+		for _, v := range m {
+			values = append(values, v)
+		}
+		for _, f := range files {
+			values = append(values, f.Content)
+		}
+
+		s.Lock()
+		s.secrets, s.refreshed, s.failed = m, time.Now(), nil
+		notify := s.notify
+		s.Unlock()
+		if notify != nil {
+			notify(values)
+		}
+		log.Debugf("Refreshed secrets. count=%d", len(m))
+	}
+}
+
+// onRefresh calls fn with the secret values after every successful refresh
+func (s *secretServer) onRefresh(fn func([]string)) {
+	s.Lock()
+	defer s.Unlock()
+	s.notify = fn
+}
+
+// rewriteFiles replaces the content of the secret files of the command
+func (s *secretServer) rewriteFiles(files []environ.File) error {
+	for _, f := range files {
+		path, ok := s.files[f.Var]
+		if !ok {
+			continue
+		}
+		if er := replaceSecretFile(path, f.Content); er != nil {
+			return fmt.Errorf("failed to rewrite secret file %s: %v", path, er)
+		}
+	}
+	return nil
+}
+
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	uid, er := peerUID(uc)
+	if er != nil {
+		log.Infof("Failed to read peer credentials. err=%v", er)
+		return ctx
+	}
+	return context.WithValue(ctx, peerKey{}, uid)
+}
+
+// authenticate refuses requests from callers whose uid is not allowed
+func (s *secretServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, ok := r.Context().Value(peerKey{}).(int)
+		if !ok {
+			uid = -1
+		}
+		if !ok || !s.uids[uid] {
+			log.Infof("Refusing secret server request. uid=%d path=%s", uid, r.URL.Path)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		log.Debugf("Secret server request. uid=%d path=%s", uid, r.URL.Path)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// health reports 503 while refreshing secrets fails
+func (s *secretServer) health(w http.ResponseWriter, r *http.Request) {
+	s.RLock()
+	defer s.RUnlock()
+
+	status := map[string]interface{}{
+		"status":    "ok",
+		"secrets":   len(s.secrets),
+		"refreshed": s.refreshed.UTC().Format(time.RFC3339),
+	}
+	if s.failed != nil {
+		status["status"], status["error"] = "stale", s.failed.Error()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJSON(w, status)
+}
+
+func (s *secretServer) list(w http.ResponseWriter, r *http.Request) {
+	s.RLock()
+	defer s.RUnlock()
+	writeJSON(w, s.secrets)
+}
+
+func (s *secretServer) get(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, secretsPath+"/")
+
+	s.RLock()
+	v, ok := s.secrets[key]
+	s.RUnlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("secret %q not found", key), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(v))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if er := json.NewEncoder(w).Encode(v); er != nil {
+		log.Infof("Failed to write secret server response. err=%v", er)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+)
+
+func TestSecretServer(t *testing.T) {
+	dir, er := ioutil.TempDir("", "vest-test-")
+	require.NoError(t, er)
+	defer os.RemoveAll(dir)
+
+	secrets := environ.New()
+	secrets.Merge(map[string]string{"DB_PASS": "hunter2", "API_KEY": "abc"})
+	s, er := newSecretServer(&config{FilesDir: dir}, secrets, nil)
+	require.NoError(t, er)
+	go s.Serve(&config{}, 0)
+	defer s.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", s.path)
+		},
+	}}
+	get := func(path string) (int, string) {
+		resp, er := client.Get("http://vest" + path)
+		require.NoError(t, er)
+		defer resp.Body.Close()
+		body, er := ioutil.ReadAll(resp.Body)
+		require.NoError(t, er)
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get(secretsPath)
+	assert.Equal(t, http.StatusOK, code)
+	var all map[string]string
+	require.NoError(t, json.Unmarshal([]byte(body), &all))
+	assert.Equal(t, map[string]string{"DB_PASS": "hunter2", "API_KEY": "abc"}, all)
+
+	code, body = get(secretsPath + "/DB_PASS")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "hunter2", body)
+
+	code, _ = get(secretsPath + "/MISSING")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = get(healthPath)
+	assert.Equal(t, http.StatusOK, code)
+
+	s.Lock()
+	s.failed = errors.New("permission denied")
+	s.Unlock()
+	code, body = get(healthPath)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "permission denied")
+}
+
+func TestSecretServerRewriteFiles(t *testing.T) {
+	dir, er := ioutil.TempDir("", "vest-test-")
+	require.NoError(t, er)
+	defer os.RemoveAll(dir)
+
+	vars, er := writeSecretFiles(dir, []environ.File{{Var: "TLS_KEY_FILE", Content: "old"}}, os.Getuid(), os.Getgid())
+	require.NoError(t, er)
+
+	s := &secretServer{files: vars}
+	require.NoError(t, s.rewriteFiles([]environ.File{
+		{Var: "TLS_KEY_FILE", Content: "new"},
+		{Var: "UNKNOWN_FILE", Content: "ignored"},
+	}))
+
+	data, er := ioutil.ReadFile(filepath.Join(dir, "TLS_KEY_FILE"))
+	require.NoError(t, er)
+	assert.Equal(t, "new", string(data))
+	fi, er := os.Stat(filepath.Join(dir, "TLS_KEY_FILE"))
+	require.NoError(t, er)
+	assert.Equal(t, secretFileMode, fi.Mode().Perm())
+	_, er = os.Stat(filepath.Join(dir, "UNKNOWN_FILE"))
+	assert.True(t, os.IsNotExist(er))
+}
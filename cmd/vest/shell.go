@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+const (
+	defaultShell     = "/bin/sh"
+	profileEnvVar    = "VEST_PROFILE"
+	shellPromptStart = "(vest:"
+)
+
+// shellPrompt returns the variable the shell reads its prompt from and the prompt used when none is set, in the
+// syntax of the shell. fish prompts are functions, so it gets no prompt variable.
+func shellPrompt(shell string) (string, string) {
+	switch filepath.Base(shell) {
+	case "bash":
+		return "PS1", `\u@\h:\w\$ `
+	case "zsh":
+		return "PROMPT", "%n@%m:%~%# "
+	case "fish":
+		return "", ""
+	default:
+		return "PS1", "$ "
+	}
+}
+
+// runShell starts an interactive $SHELL with secrets loaded and a prompt marker showing the profile. The shell is
+// supervised, so credential files written by providers for it go to the private secret files directory, which is
+// removed once the shell exits.
+func runShell(conf *config, secrets *environ.Environ) error {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = defaultShell
+	}
+
+	profile := conf.Profile
+	if profile == "" {
+		profile = strings.Join(conf.Providers, "+")
+	}
+
+	keys := sortedKeys(secrets.Map())
+	log.Infof("Starting shell. shell=%s profile=%s keys=%s", shell, profile, strings.Join(keys, ","))
+	fmt.Fprintf(os.Stderr, "vest: loaded %d secrets (%s) into %s\n", len(keys), strings.Join(keys, ", "), shell)
+
+	conf.Command = []string{shell}
+	conf.Supervise = true
+	return execCommand(conf, secrets, shellVars(shell, profile))
+}
+
+// shellVars returns the variables set for the shell: the profile, and the prompt marker put in front of the prompt
+// the shell would have shown
+func shellVars(shell, profile string) map[string]string {
+	vars := map[string]string{profileEnvVar: profile}
+	if name, prompt := shellPrompt(shell); name != "" {
+		if v := os.Getenv(name); v != "" {
+			prompt = v
+		}
+		vars[name] = shellPromptStart + profile + ") " + prompt
+	}
+	return vars
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellVars(t *testing.T) {
+	for _, name := range []string{"PS1", "PROMPT"} {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Unsetenv(name)
+	}
+
+	tests := []struct {
+		shell, prompt string
+		want          map[string]string
+	}{
+		{"/bin/bash", "", map[string]string{"VEST_PROFILE": "prod", "PS1": `(vest:prod) \u@\h:\w\$ `}},
+		{"/usr/local/bin/bash", "[\\w]$ ", map[string]string{"VEST_PROFILE": "prod", "PS1": "(vest:prod) [\\w]$ "}},
+		{"/bin/zsh", "", map[string]string{"VEST_PROFILE": "prod", "PROMPT": "(vest:prod) %n@%m:%~%# "}},
+		{"/bin/zsh", "%~ > ", map[string]string{"VEST_PROFILE": "prod", "PROMPT": "(vest:prod) %~ > "}},
+		{"/usr/bin/fish", "", map[string]string{"VEST_PROFILE": "prod"}},
+		{"/bin/dash", "", map[string]string{"VEST_PROFILE": "prod", "PS1": "(vest:prod) $ "}},
+		{"/bin/sh", "# ", map[string]string{"VEST_PROFILE": "prod", "PS1": "(vest:prod) # "}},
+	}
+	for _, tt := range tests {
+		name, _ := shellPrompt(tt.shell)
+		if name != "" {
+			os.Setenv(name, tt.prompt)
+		}
+		assert.Equalf(t, tt.want, shellVars(tt.shell, "prod"), "%s %q", tt.shell, tt.prompt)
+		if name != "" {
+			os.Unsetenv(name)
+		}
+	}
+}
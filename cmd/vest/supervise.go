@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+// supervise runs the command as a child of vest instead of replacing it, forwarding every signal vest receives to
+// the child. Returns the exit code of the child once it exits.
+func supervise(cmd *exec.Cmd) (int, error) {
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals)
+	defer signal.Stop(signals)
+
+	if er := cmd.Start(); er != nil {
+		return 1, er
+	}
+	log.Debugf("Started supervised command. pid=%d cmd=%s", cmd.Process.Pid, cmd.Path)
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	for {
+		select {
+		case sig := <-signals:
+			// SIGCHLD is for us, the rest belong to the child
+			if sig == syscall.SIGCHLD || sig == syscall.SIGURG {
+				continue
+			}
+			log.Debugf("Forwarding signal to supervised command. pid=%d signal=%v", cmd.Process.Pid, sig)
+			cmd.Process.Signal(sig)
+		case er := <-done:
+			return exitCode(cmd, er), nil
+		}
+	}
+}
+
+// exitCode returns the exit code of the exited command, following the shell convention of 128+n for signals
+func exitCode(cmd *exec.Cmd, er error) int {
+	if cmd.ProcessState == nil {
+		if er != nil {
+			return 1
+		}
+		return 0
+	}
+
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return cmd.ProcessState.ExitCode()
+}
+
+// exitError is returned when a supervised command exits non-zero
+type exitError struct {
+	code int
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("command exited with code %d", e.code)
+}
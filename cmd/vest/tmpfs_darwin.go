@@ -0,0 +1,6 @@
+package main
+
+// isTmpfs returns false, darwin has no tmpfs to write secret files to
+func isTmpfs(dir string) bool {
+	return false
+}
@@ -0,0 +1,15 @@
+package main
+
+import "syscall"
+
+// tmpfsMagic is TMPFS_MAGIC from linux/magic.h
+const tmpfsMagic = 0x01021994
+
+// isTmpfs returns true if dir is on a tmpfs
+func isTmpfs(dir string) bool {
+	var st syscall.Statfs_t
+	if er := syscall.Statfs(dir, &st); er != nil {
+		return false
+	}
+	return st.Type == tmpfsMagic
+}
@@ -1,43 +1,28 @@
 package environ
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"regexp"
 	"sort"
 	"strings"
 	"sync"
 
-	"github.com/BurntSushi/toml"
-
-	"gopkg.in/yaml.v2"
-
-	"github.com/joho/godotenv"
-
 	"github.com/lumoslabs/vestibule/pkg/log"
 )
 
 // 1 or more non-word characters
 const regex = "[^0-9A-Za-z_]+"
 
-var marshalFuncs = map[string]marshaller{
-	"json":   json.Marshal,
-	"yaml":   yaml.Marshal,
-	"yml":    yaml.Marshal,
-	"toml":   marshalToml,
-	"env":    marshalDotEnv,
-	"dotenv": marshalDotEnv,
-}
-
 // New returns a new blank Environ instance
 func New() *Environ {
 	return &Environ{
 		m:          make(map[string]string),
 		re:         regexp.MustCompile(regex),
-		marshaller: json.Marshal,
+		marshaller: encodeWith(json.Marshal),
 		UpcaseKeys: true,
 	}
 }
@@ -54,42 +39,54 @@ func NewFromEnv() *Environ {
 	return &Environ{
 		m:          e,
 		re:         regexp.MustCompile(regex),
-		marshaller: json.Marshal,
+		marshaller: encodeWith(json.Marshal),
 		UpcaseKeys: true,
 	}
 }
 
-// Marshallers returns a list of all valid serializers extensions
-func Marshallers() []string {
-	marshallers := make([]string, 0, len(marshalFuncs))
-	for k := range marshalFuncs {
-		marshallers = append(marshallers, k)
-	}
-	sort.Strings(marshallers)
-	return marshallers
-}
-
-// Populate adds secrets to the Environ from the given providers
-func (e *Environ) Populate(providers []string) {
-	var wg sync.WaitGroup
-
-	for _, name := range providers {
+// Populate adds secrets to the Environ from the given providers. Providers run concurrently, but their secrets are
+// merged in the order the providers are given without overwriting keys, so the first provider to set a name wins.
+// Providers which fail are skipped and an error listing every failure is returned.
+func (e *Environ) Populate(providers []string) error {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errs   []string
+		staged = make([]*Environ, len(providers))
+	)
+
+	for i, name := range providers {
 		provider, er := GetProvider(name)
 		if er != nil {
 			log.Infof("Skipping provider: %v", er)
+			errs = append(errs, fmt.Sprintf("%s: %v", name, er))
 			continue
 		}
 
+		staged[i] = e.stage()
 		wg.Add(1)
-		go func() {
+		go func(name string, provider Provider, staged *Environ) {
 			defer wg.Done()
-			if er := provider.AddToEnviron(e); er != nil {
+			if er := provider.AddToEnviron(staged); er != nil {
 				log.Infof("Failed to add secrets to Environ. provider=%s msg=%s", name, er.Error())
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %v", name, er))
+				mu.Unlock()
 			}
-		}()
+		}(name, provider, staged[i])
 	}
 
 	wg.Wait()
+	for i, name := range providers {
+		if staged[i] != nil {
+			e.mergeProvider(name, staged[i])
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("Failed to populate Environ: %s", strings.Join(errs, "; "))
+	}
+	return nil
 }
 
 // Merge takes a map[string]string and adds it to this Environ, overwriting any conflicting keys.
@@ -153,9 +150,30 @@ func (e *Environ) Delete(key string) (v string) {
 
 	v = e.m[key]
 	delete(e.m, key)
+	delete(e.sources, key)
+	if e.parent != nil {
+		e.parent.Delete(key)
+	}
 	return
 }
 
+// Extract removes every key matching one of the given glob patterns, as understood by path.Match, from this
+// Environ and returns them
+func (e *Environ) Extract(patterns ...string) map[string]string {
+	e.Lock()
+	defer e.Unlock()
+
+	extracted := make(map[string]string)
+	for k, v := range e.m {
+		if key := e.normalize(k); MatchAny(patterns, key) {
+			extracted[key] = v
+			delete(e.m, k)
+			delete(e.sources, k)
+		}
+	}
+	return extracted
+}
+
 // Len returns the length of this Environ
 func (e *Environ) Len() (l int) {
 	e.RLock()
@@ -171,11 +189,7 @@ func (e *Environ) Slice() []string {
 	e.RLock()
 	var s = make([]string, 0, e.Len())
 	for k, v := range e.m {
-		key := e.re.ReplaceAllString(k, "_")
-		if e.UpcaseKeys {
-			key = strings.ToUpper(key)
-		}
-		s = append(s, key+"="+v)
+		s = append(s, e.normalize(k)+"="+v)
 	}
 	e.RUnlock()
 
@@ -190,58 +204,88 @@ func (e *Environ) Map() map[string]string {
 
 	dup := make(map[string]string, len(e.m))
 	for k, v := range e.m {
-		key := e.re.ReplaceAllString(k, "_")
-		if e.UpcaseKeys {
-			key = strings.ToUpper(key)
-		}
-		dup[key] = v
+		dup[e.normalize(k)] = v
 	}
 
 	return dup
 }
 
+// Sources returns a map of each key gathered by Populate to the name of the provider it came from
+func (e *Environ) Sources() map[string]string {
+	e.RLock()
+	defer e.RUnlock()
+
+	dup := make(map[string]string, len(e.sources))
+	for k, provider := range e.sources {
+		dup[e.normalize(k)] = provider
+	}
+	return dup
+}
+
 // String returns a stringified representation of this Environ
 func (e *Environ) String() string {
 	return fmt.Sprintf("%#q", e.Slice())
 }
 
-// SetMarshaller sets the marshalling function for the Environ object.
-func (e *Environ) SetMarshaller(m string) {
-	if fn, ok := marshalFuncs[strings.ToLower(m)]; ok {
-		e.marshaller = fn
-	} else {
-		e.marshaller = marshalFuncs["json"]
+// SetMarshaller sets the format Write marshals the Environ in, or returns an error if it is unknown
+func (e *Environ) SetMarshaller(name string) error {
+	f, er := LookupFormat(name)
+	if er != nil {
+		return er
+	}
+	if f.Encode == nil {
+		return fmt.Errorf("format %s cannot be marshalled to", f.Name)
 	}
+	e.marshaller = f.Encode
+	return nil
 }
 
-// Write writes the marshalled byte slice of the underlying map to the given io.Writer
+// Write streams the underlying map to the given io.Writer in the format set with SetMarshaller
 func (e *Environ) Write(w io.Writer) error {
 	e.RLock()
 	defer e.RUnlock()
 
-	out, er := e.marshaller(e.Map())
-	if er != nil {
-		return er
-	}
+	return e.marshaller(w, e.Map())
+}
 
-	_, er = w.Write(out)
-	return er
+// stage returns a new blank Environ for a single provider to add secrets to. Deletes are passed through to this Environ.
+func (e *Environ) stage() *Environ {
+	return &Environ{
+		m:          make(map[string]string),
+		re:         e.re,
+		marshaller: e.marshaller,
+		UpcaseKeys: e.UpcaseKeys,
+		parent:     e,
+	}
 }
 
-func marshalDotEnv(in interface{}) ([]byte, error) {
-	inTyped, ok := in.(map[string]string)
-	if !ok {
-		return []byte(nil), fmt.Errorf("Invalid input type: %T", inTyped)
+// raw returns a copy of the underlying map[string]string without normalizing keys
+func (e *Environ) raw() map[string]string {
+	e.RLock()
+	defer e.RUnlock()
+
+	dup := make(map[string]string, len(e.m))
+	for k, v := range e.m {
+		dup[k] = v
 	}
+	return dup
+}
 
-	out, er := godotenv.Marshal(inTyped)
-	return []byte(out), er
+// normalize returns the key as it will be exposed in the environment
+func (e *Environ) normalize(k string) string {
+	key := e.re.ReplaceAllString(k, "_")
+	if e.UpcaseKeys {
+		key = strings.ToUpper(key)
+	}
+	return key
 }
 
-func marshalToml(in interface{}) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	if er := toml.NewEncoder(buf).Encode(in); er != nil {
-		return []byte(nil), er
+// MatchAny returns true if the key matches any of the glob patterns, as understood by path.Match
+func MatchAny(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
 	}
-	return buf.Bytes(), nil
+	return false
 }
@@ -0,0 +1,78 @@
+package environ
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+const (
+	fileMode = os.FileMode(0600)
+	dirMode  = os.FileMode(0755)
+)
+
+// AddFile adds a File to the Environ without overwriting a File for the same variable
+func (e *Environ) AddFile(f File) {
+	e.Lock()
+	defer e.Unlock()
+
+	e.addFile(f)
+}
+
+// addFile adds a File like AddFile, with the lock already held
+func (e *Environ) addFile(f File) {
+	if e.files == nil {
+		e.files = make(map[string]File)
+	}
+	if _, ok := e.files[f.Var]; !ok {
+		e.files[f.Var] = f
+	}
+}
+
+// Files returns the Files added to the Environ, sorted by variable
+func (e *Environ) Files() []File {
+	e.RLock()
+	defer e.RUnlock()
+
+	files := make([]File, 0, len(e.files))
+	for _, f := range e.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Var < files[j].Var })
+	return files
+}
+
+// WriteFiles writes each File to its Path with mode 0600, owned by uid and gid unless they are -1, and sets its
+// variable to the Path. The Files are then removed from the Environ.
+func (e *Environ) WriteFiles(uid, gid int) error {
+	for _, f := range e.Files() {
+		log.Debugf("Writing file. var=%s file=%s", f.Var, f.Path)
+		if er := writeFile(f, uid, gid); er != nil {
+			return fmt.Errorf("failed to write %s: %v", f.Path, er)
+		}
+		e.SafeMerge(map[string]string{f.Var: f.Path})
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	e.files = nil
+	return nil
+}
+
+func writeFile(f File, uid, gid int) error {
+	if er := os.MkdirAll(filepath.Dir(f.Path), dirMode); er != nil {
+		return er
+	}
+	if er := ioutil.WriteFile(f.Path, []byte(f.Content), fileMode); er != nil {
+		return er
+	}
+	// WriteFile keeps the mode of an existing file
+	if er := os.Chmod(f.Path, fileMode); er != nil {
+		return er
+	}
+	return os.Chown(f.Path, uid, gid)
+}
@@ -0,0 +1,84 @@
+package environ
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fileProvider []File
+
+func (fp fileProvider) AddToEnviron(e *Environ) error {
+	for _, f := range fp {
+		e.AddFile(f)
+	}
+	return nil
+}
+
+func TestAddFile(t *testing.T) {
+	e := New()
+	e.AddFile(File{Var: "B_FILE", Path: "/b", Content: "b"})
+	e.AddFile(File{Var: "A_FILE", Path: "/a", Content: "first"})
+	e.AddFile(File{Var: "A_FILE", Path: "/other", Content: "second"})
+
+	assert.Equal(t, []File{
+		{Var: "A_FILE", Path: "/a", Content: "first"},
+		{Var: "B_FILE", Path: "/b", Content: "b"},
+	}, e.Files())
+	assert.Equal(t, 0, e.Len())
+}
+
+func TestWriteFiles(t *testing.T) {
+	dir, er := ioutil.TempDir("", "environ-")
+	require.NoError(t, er)
+	defer os.RemoveAll(dir)
+
+	existing := filepath.Join(dir, "existing")
+	require.NoError(t, ioutil.WriteFile(existing, []byte("old"), 0644))
+
+	e := New()
+	e.Set("CREDS_FILE", "/set/by/another/provider")
+	e.AddFile(File{Var: "KEY_FILE", Path: filepath.Join(dir, "nested", "key.json"), Content: "{}"})
+	e.AddFile(File{Var: "CREDS_FILE", Path: existing, Content: "new"})
+	require.NoError(t, e.WriteFiles(-1, -1))
+
+	for path, content := range map[string]string{filepath.Join(dir, "nested", "key.json"): "{}", existing: "new"} {
+		data, er := ioutil.ReadFile(path)
+		require.NoError(t, er)
+		assert.Equal(t, content, string(data))
+
+		fi, er := os.Stat(path)
+		require.NoError(t, er)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm(), path)
+	}
+
+	assert.Equal(t, map[string]string{
+		"KEY_FILE":   filepath.Join(dir, "nested", "key.json"),
+		"CREDS_FILE": "/set/by/another/provider",
+	}, e.Map())
+	assert.Empty(t, e.Files())
+}
+
+func TestPopulateFiles(t *testing.T) {
+	RegisterProvider("files-first", func() (Provider, error) {
+		return fileProvider{{Var: "CREDS_FILE", Path: "/first", Content: "first"}, {Var: "LD_PRELOAD", Path: "/evil.so"}}, nil
+	})
+	RegisterProvider("files-second", func() (Provider, error) {
+		return fileProvider{{Var: "CREDS_FILE", Path: "/second", Content: "second"}, {Var: "KEY_FILE", Path: "/key"}}, nil
+	})
+	defer func() {
+		delete(providers, "files-first")
+		delete(providers, "files-second")
+	}()
+
+	e := New()
+	assert.NoError(t, e.Populate([]string{"files-first", "files-second"}))
+	assert.Equal(t, []File{
+		{Var: "CREDS_FILE", Path: "/first", Content: "first"},
+		{Var: "KEY_FILE", Path: "/key"},
+	}, e.Files())
+}
@@ -0,0 +1,169 @@
+package environ
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	formatsMu  sync.RWMutex
+	formats    = make(map[string]*Format)
+	extensions = make(map[string]string)
+)
+
+func init() {
+	RegisterFormat("json", encodeWith(json.Marshal), decodeWith(json.Unmarshal), "application/json", ".json")
+	RegisterFormat("yaml", encodeWith(yaml.Marshal), decodeWith(yaml.Unmarshal), "application/yaml", ".yaml", ".yml")
+	RegisterFormat("yml", encodeWith(yaml.Marshal), decodeWith(yaml.Unmarshal), "application/yaml")
+	RegisterFormat("toml", encodeToml, decodeWith(toml.Unmarshal), "application/toml", ".toml")
+	RegisterFormat("dotenv", encodeDotEnv, decodeDotEnv, "text/plain; charset=utf-8", ".env")
+	RegisterFormat("env", encodeDotEnv, decodeDotEnv, "text/plain; charset=utf-8")
+}
+
+// RegisterFormat adds a format secrets can be marshalled to with enc and unmarshalled from with dec, replacing any
+// format of the same name. Either may be nil for a format which only goes one way. Files with the given extensions,
+// such as ".json", are taken to be in this format.
+func RegisterFormat(name string, enc Encoder, dec Decoder, contentType string, exts ...string) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	name = strings.ToLower(name)
+	formats[name] = &Format{Name: name, ContentType: contentType, Extensions: exts, Encode: enc, Decode: dec}
+	for _, ext := range exts {
+		extensions[strings.ToLower(ext)] = name
+	}
+}
+
+// LookupFormat returns the named format, or an error if it is unknown
+func LookupFormat(name string) (*Format, error) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	f, ok := formats[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %s", name)
+	}
+	return f, nil
+}
+
+// FormatForFile returns the name of the format registered for the extension of the file, or false if there is none
+func FormatForFile(path string) (string, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	name, ok := extensions[strings.ToLower(filepath.Ext(path))]
+	return name, ok
+}
+
+// Marshallers returns the sorted names of the formats secrets can be marshalled to
+func Marshallers() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	names := make([]string, 0, len(formats))
+	for name, f := range formats {
+		if f.Encode != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Marshal returns the map marshalled in the named format, or an error if the format is unknown
+func Marshal(format string, m map[string]string) ([]byte, error) {
+	f, er := LookupFormat(format)
+	if er != nil {
+		return nil, er
+	}
+	if f.Encode == nil {
+		return nil, fmt.Errorf("format %s cannot be marshalled to", f.Name)
+	}
+	buf := new(bytes.Buffer)
+	if er := f.Encode(buf, m); er != nil {
+		return nil, er
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal returns the key / value pairs of data in the named format, or an error if the format is unknown.
+// Values which are not strings are formatted as they would be with fmt.Sprint.
+func Unmarshal(format string, data []byte) (map[string]string, error) {
+	f, er := LookupFormat(format)
+	if er != nil {
+		return nil, er
+	}
+	if f.Decode == nil {
+		return nil, fmt.Errorf("format %s cannot be unmarshalled from", f.Name)
+	}
+	return f.Decode(data)
+}
+
+// encodeWith returns an Encoder marshalling the map with fn
+func encodeWith(fn func(interface{}) ([]byte, error)) Encoder {
+	return func(w io.Writer, m map[string]string) error {
+		out, er := fn(m)
+		if er != nil {
+			return er
+		}
+		_, er = w.Write(out)
+		return er
+	}
+}
+
+// decodeWith returns a Decoder unmarshalling a map with fn
+func decodeWith(fn func([]byte, interface{}) error) Decoder {
+	return func(data []byte) (map[string]string, error) {
+		var m map[string]interface{}
+		if er := fn(data, &m); er != nil {
+			return nil, er
+		}
+
+		out := make(map[string]string, len(m))
+		for k, v := range m {
+			if s, ok := v.(string); ok {
+				out[k] = s
+			} else {
+				out[k] = fmt.Sprint(v)
+			}
+		}
+		return out, nil
+	}
+}
+
+func encodeDotEnv(w io.Writer, m map[string]string) error {
+	out, er := godotenv.Marshal(m)
+	if er != nil {
+		return er
+	}
+	_, er = io.WriteString(w, out)
+	return er
+}
+
+func decodeDotEnv(data []byte) (map[string]string, error) {
+	return godotenv.Unmarshal(string(data))
+}
+
+func encodeToml(w io.Writer, m map[string]string) error {
+	return toml.NewEncoder(w).Encode(m)
+}
+
+// sortedNames returns the sorted keys of the map
+func sortedNames(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,111 @@
+package environ
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterFormat(t *testing.T) {
+	enc := func(w io.Writer, m map[string]string) error {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		lines := make([]string, 0, len(m))
+		for _, k := range keys {
+			lines = append(lines, k+":"+m[k])
+		}
+		_, er := io.WriteString(w, strings.Join(lines, "\n"))
+		return er
+	}
+	dec := func(data []byte) (map[string]string, error) {
+		m := make(map[string]string)
+		for _, line := range strings.Split(string(data), "\n") {
+			bits := strings.SplitN(line, ":", 2)
+			if len(bits) != 2 {
+				return nil, fmt.Errorf("invalid line %q", line)
+			}
+			m[bits[0]] = bits[1]
+		}
+		return m, nil
+	}
+	RegisterFormat("colon", enc, dec, "text/x-colon", ".colon", ".COL")
+	RegisterFormat("colon-out", enc, nil, "text/x-colon")
+	defer func() {
+		formatsMu.Lock()
+		defer formatsMu.Unlock()
+		delete(formats, "colon")
+		delete(formats, "colon-out")
+		delete(extensions, ".colon")
+		delete(extensions, ".col")
+	}()
+
+	f, er := LookupFormat("Colon")
+	require.NoError(t, er)
+	assert.Equal(t, "text/x-colon", f.ContentType)
+	assert.Equal(t, []string{".colon", ".COL"}, f.Extensions)
+	assert.Contains(t, Marshallers(), "colon")
+	assert.Contains(t, Marshallers(), "colon-out")
+
+	in := map[string]string{"A": "1", "B": "two"}
+	data, er := Marshal("colon", in)
+	require.NoError(t, er)
+	assert.Equal(t, "A:1\nB:two", string(data))
+	out, er := Unmarshal("colon", data)
+	require.NoError(t, er)
+	assert.Equal(t, in, out)
+
+	_, er = Unmarshal("colon-out", data)
+	assert.Error(t, er, "write only format")
+
+	e := New()
+	e.SafeMerge(in)
+	require.NoError(t, e.SetMarshaller("colon"))
+	buf := new(bytes.Buffer)
+	require.NoError(t, e.Write(buf))
+	assert.Equal(t, "A:1\nB:two", buf.String())
+	assert.Error(t, e.SetMarshaller("nope"))
+
+	_, er = LookupFormat("nope")
+	assert.Error(t, er)
+}
+
+func TestFormatForFile(t *testing.T) {
+	RegisterFormat("dots", nil, nil, "", ".dots")
+	tests := map[string]string{
+		"app.json":            "json",
+		"/etc/app/config.yml": "yaml",
+		"config.YAML":         "yaml",
+		"app.toml":            "toml",
+		".env":                "dotenv",
+		"prod.env":            "dotenv",
+		"app.dots":            "dots",
+	}
+	for path, want := range tests {
+		got, ok := FormatForFile(path)
+		assert.Truef(t, ok, path)
+		assert.Equalf(t, want, got, path)
+	}
+
+	_, ok := FormatForFile("secrets")
+	assert.False(t, ok)
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	m := map[string]string{"A": "1", "NOT\x00VALID": "x", "Z": "2"}
+	for _, format := range []string{"bash", "hcl", "xml"} {
+		f, er := LookupFormat(format)
+		require.NoError(t, er)
+		buf := new(bytes.Buffer)
+		assert.Errorf(t, f.Encode(buf, m), format)
+		assert.Emptyf(t, buf.String(), "%s: nothing is written for an invalid secret", format)
+	}
+}
@@ -0,0 +1,313 @@
+package environ
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// hclNameRE matches the identifiers HCL accepts as attribute names
+var hclNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+func init() {
+	RegisterFormat("hcl", encodeHCL, decodeHCL, "text/x-hcl", ".hcl", ".tfvars")
+	RegisterFormat("tfvars", encodeHCL, decodeHCL, "text/x-hcl")
+}
+
+// encodeHCL writes a string attribute per secret, sorted by name, as Terraform reads a .tfvars file. Quotes,
+// backslashes and control characters are escaped, and ${ and %{ are doubled so Terraform does not take them for
+// interpolation. Names HCL cannot set are an error.
+func encodeHCL(w io.Writer, m map[string]string) error {
+	keys := sortedNames(m)
+	for _, k := range keys {
+		if !hclNameRE.MatchString(k) {
+			return fmt.Errorf("%q is not a valid HCL attribute name", k)
+		}
+	}
+
+	buf := bufio.NewWriter(w)
+	for _, k := range keys {
+		fmt.Fprintf(buf, "%s = \"%s\"\n", k, escapeHCL(m[k]))
+	}
+	return buf.Flush()
+}
+
+// escapeHCL returns s escaped for a quoted HCL string
+func escapeHCL(s string) string {
+	buf := new(bytes.Buffer)
+	for i, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\r':
+			buf.WriteString(`\r`)
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case (r == '$' || r == '%') && strings.HasPrefix(s[i+1:], "{"):
+			buf.WriteRune(r)
+			buf.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(buf, `\u%04X`, r)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+// decodeHCL reads the top level attributes of a .tfvars file whose values are strings, heredocs, numbers or bools.
+// Comments are skipped. Strings containing interpolation, and lists or objects, are an error.
+func decodeHCL(data []byte) (map[string]string, error) {
+	p := &hclParser{src: string(data), line: 1}
+	m := make(map[string]string)
+	for {
+		p.skip(true)
+		if p.done() {
+			return m, nil
+		}
+
+		name := p.name()
+		if name == "" {
+			return nil, p.errorf("expected an attribute name")
+		}
+		p.skip(false)
+		if !p.consume("=") {
+			return nil, p.errorf("expected = after %s", name)
+		}
+		p.skip(false)
+		v, er := p.value()
+		if er != nil {
+			return nil, fmt.Errorf("%s: %v", name, er)
+		}
+		m[name] = v
+
+		p.skip(false)
+		if !p.done() && !p.consume("\n") {
+			return nil, p.errorf("expected a new line after %s", name)
+		}
+		p.line++
+	}
+}
+
+// hclParser reads attributes from src, tracking the line for errors
+type hclParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *hclParser) done() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *hclParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *hclParser) consume(s string) bool {
+	if strings.HasPrefix(p.src[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+// skip moves past spaces and comments, and past new lines too when newlines is set
+func (p *hclParser) skip(newlines bool) {
+	for !p.done() {
+		rest := p.src[p.pos:]
+		switch {
+		case rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\r':
+			p.pos++
+		case rest[0] == '\n' && newlines:
+			p.pos++
+			p.line++
+		case strings.HasPrefix(rest, "#") || strings.HasPrefix(rest, "//"):
+			if i := strings.IndexByte(rest, '\n'); i >= 0 {
+				p.pos += i
+			} else {
+				p.pos = len(p.src)
+			}
+		case strings.HasPrefix(rest, "/*"):
+			i := strings.Index(rest, "*/")
+			if i < 0 {
+				i = len(rest) - 2
+			}
+			p.line += strings.Count(rest[:i], "\n")
+			p.pos += i + 2
+		default:
+			return
+		}
+	}
+}
+
+func (p *hclParser) name() string {
+	end := p.pos
+	for end < len(p.src) && strings.IndexByte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-", p.src[end]) >= 0 {
+		end++
+	}
+	name := p.src[p.pos:end]
+	if !hclNameRE.MatchString(name) {
+		return ""
+	}
+	p.pos = end
+	return name
+}
+
+func (p *hclParser) value() (string, error) {
+	rest := p.src[p.pos:]
+	switch {
+	case strings.HasPrefix(rest, `"`):
+		return p.quoted()
+	case strings.HasPrefix(rest, "<<"):
+		return p.heredoc()
+	}
+
+	end := strings.IndexAny(rest, " \t\r\n#/")
+	if end < 0 {
+		end = len(rest)
+	}
+	literal := rest[:end]
+	if literal != "true" && literal != "false" {
+		if _, er := strconv.ParseFloat(literal, 64); er != nil {
+			return "", p.errorf("unsupported value %q", literal)
+		}
+	}
+	p.pos += end
+	return literal, nil
+}
+
+// quoted reads a quoted string, replacing its escapes and the doubled $${ and %%{
+func (p *hclParser) quoted() (string, error) {
+	p.pos++
+	buf := new(bytes.Buffer)
+	for !p.done() {
+		rest := p.src[p.pos:]
+		switch {
+		case rest[0] == '"':
+			p.pos++
+			return buf.String(), nil
+		case rest[0] == '\n':
+			return "", p.errorf("unterminated string")
+		case strings.HasPrefix(rest, "$${") || strings.HasPrefix(rest, "%%{"):
+			buf.WriteString(rest[1:3])
+			p.pos += 3
+		case strings.HasPrefix(rest, "${") || strings.HasPrefix(rest, "%{"):
+			return "", p.errorf("interpolation is not supported")
+		case rest[0] == '\\':
+			n, er := p.escape(buf, rest)
+			if er != nil {
+				return "", er
+			}
+			p.pos += n
+		default:
+			r, n := utf8.DecodeRuneInString(rest)
+			buf.WriteRune(r)
+			p.pos += n
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// escape writes the character the escape at the start of s stands for, returning the length of the escape
+func (p *hclParser) escape(buf *bytes.Buffer, s string) (int, error) {
+	if len(s) < 2 {
+		return 0, p.errorf("unterminated string")
+	}
+	switch s[1] {
+	case 'n':
+		buf.WriteByte('\n')
+	case 'r':
+		buf.WriteByte('\r')
+	case 't':
+		buf.WriteByte('\t')
+	case '"', '\\':
+		buf.WriteByte(s[1])
+	case 'u', 'U':
+		size := 4
+		if s[1] == 'U' {
+			size = 8
+		}
+		if len(s) < 2+size {
+			return 0, p.errorf("malformed \\%c escape", s[1])
+		}
+		r, er := strconv.ParseUint(s[2:2+size], 16, 32)
+		if er != nil || !utf8.ValidRune(rune(r)) {
+			return 0, p.errorf("malformed \\%c escape", s[1])
+		}
+		buf.WriteRune(rune(r))
+		return 2 + size, nil
+	default:
+		return 0, p.errorf("unknown escape \\%c", s[1])
+	}
+	return 2, nil
+}
+
+// heredoc reads a <<ID or <<-ID heredoc, the latter with the indent common to its lines removed
+func (p *hclParser) heredoc() (string, error) {
+	p.pos += 2
+	indented := p.consume("-")
+	end := strings.IndexByte(p.src[p.pos:], '\n')
+	if end < 0 {
+		return "", p.errorf("unterminated heredoc")
+	}
+	marker := strings.TrimSpace(p.src[p.pos : p.pos+end])
+	if !hclNameRE.MatchString(marker) {
+		return "", p.errorf("invalid heredoc marker %q", marker)
+	}
+	p.pos += end + 1
+	p.line++
+
+	var lines []string
+	for !p.done() {
+		line := p.src[p.pos:]
+		if i := strings.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i]
+		}
+		p.pos += len(line)
+		if strings.TrimSpace(line) == marker {
+			return joinHeredoc(lines, indented), nil
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\r"))
+		p.consume("\n")
+		p.line++
+	}
+	return "", p.errorf("unterminated heredoc %s", marker)
+}
+
+// joinHeredoc returns the lines of a heredoc, each ending in a new line, with $${ and %%{ undoubled
+func joinHeredoc(lines []string, indented bool) string {
+	if indented {
+		indent := -1
+		for _, line := range lines {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			if n := len(line) - len(strings.TrimLeft(line, " \t")); indent < 0 || n < indent {
+				indent = n
+			}
+		}
+		for i, line := range lines {
+			if indent < 0 || len(line) < indent {
+				lines[i] = strings.TrimLeft(line, " \t")
+			} else {
+				lines[i] = line[indent:]
+			}
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return strings.NewReplacer("$${", "${", "%%{", "%{").Replace(buf.String())
+}
@@ -0,0 +1,62 @@
+package environ
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHCLEncode(t *testing.T) {
+	out, er := Marshal("tfvars", map[string]string{
+		"db_password": "p\"w\\d ${var.x} %{if} $5",
+		"pem":         "a\nb\x01",
+		"unicode":     "é 😀",
+	})
+	require.NoError(t, er)
+	assert.Equal(t, `db_password = "p\"w\\d $${var.x} %%{if} $5"
+pem = "a\nb\u0001"
+unicode = "é 😀"
+`, string(out))
+
+	_, er = Marshal("hcl", map[string]string{"1bad": "x"})
+	assert.Error(t, er)
+}
+
+// TestHCLDecode reads a .tfvars file as it would be written by hand
+func TestHCLDecode(t *testing.T) {
+	in := `# comment
+// another
+/* block
+   comment */
+region   = "us-east-1" # trailing
+count    = 3
+enabled  = true
+ratio    = -1.5
+escaped  = "é\U0001F600 \t $${x}"
+script = <<EOF
+#!/bin/sh
+echo "$${x}"
+EOF
+indented = <<-EOT
+    one
+      two
+    EOT
+`
+	out, er := Unmarshal("hcl", []byte(in))
+	require.NoError(t, er)
+	assert.Equal(t, map[string]string{
+		"region":   "us-east-1",
+		"count":    "3",
+		"enabled":  "true",
+		"ratio":    "-1.5",
+		"escaped":  "é😀 \t ${x}",
+		"script":   "#!/bin/sh\necho \"${x}\"\n",
+		"indented": "one\n  two\n",
+	}, out)
+
+	for _, in := range []string{`a = "${var.x}"`, `a = ["x"]`, `a = "open`, `= "x"`, "a = <<EOF\nx\n"} {
+		_, er := Unmarshal("hcl", []byte(in))
+		assert.Errorf(t, er, in)
+	}
+}
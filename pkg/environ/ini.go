@@ -0,0 +1,94 @@
+package environ
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+func init() {
+	RegisterFormat("ini", encodeIni, decodeIni, "text/plain; charset=utf-8", ".ini")
+}
+
+// encodeIni writes a secret named section.key as key in [section], splitting at the last dot, and a secret without a
+// dot before the first section. Values which an INI reader would trim, unquote or cut at a comment, and multi-line
+// values, are wrapped in """.
+func encodeIni(w io.Writer, m map[string]string) error {
+	sections := make(map[string]map[string]string)
+	for k, v := range m {
+		section, key := "", k
+		if i := strings.LastIndex(k, "."); i >= 0 {
+			section, key = k[:i], k[i+1:]
+		}
+		if !iniNameOK(key) || strings.ContainsAny(section, "[]\n\r") {
+			return fmt.Errorf("%q is not a valid INI key", k)
+		}
+		if strings.Contains(v, `"""`) {
+			return fmt.Errorf("value of %s cannot be written to INI as it contains \"\"\"", k)
+		}
+		if sections[section] == nil {
+			sections[section] = make(map[string]string)
+		}
+		sections[section][key] = v
+	}
+
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := bufio.NewWriter(w)
+	for i, name := range names {
+		if name != "" {
+			if i > 0 {
+				buf.WriteByte('\n')
+			}
+			fmt.Fprintf(buf, "[%s]\n", name)
+		}
+		section := sections[name]
+		for _, k := range sortedNames(section) {
+			v := section[k]
+			if iniNeedsQuotes(v) {
+				v = `"""` + v + `"""`
+			}
+			fmt.Fprintf(buf, "%s = %s\n", k, v)
+		}
+	}
+	return buf.Flush()
+}
+
+// iniNameOK returns whether the key can be written unquoted
+func iniNameOK(k string) bool {
+	return k != "" && k == strings.TrimSpace(k) && !strings.ContainsAny(k, "=:[]#;`\"\n\r") && k != "-"
+}
+
+// iniNeedsQuotes returns whether the value must be wrapped in """ to be read back unchanged
+func iniNeedsQuotes(v string) bool {
+	return v != strings.TrimSpace(v) || strings.ContainsAny(v, "\n\r`#;") ||
+		strings.HasPrefix(v, `"`) || strings.HasPrefix(v, "'") || strings.HasSuffix(v, `\`)
+}
+
+// decodeIni reads the keys of the default section by name, and the keys of other sections as section.key
+func decodeIni(data []byte) (map[string]string, error) {
+	f, er := ini.Load(data)
+	if er != nil {
+		return nil, er
+	}
+
+	m := make(map[string]string)
+	for _, section := range f.Sections() {
+		prefix := section.Name() + "."
+		if section.Name() == ini.DEFAULT_SECTION {
+			prefix = ""
+		}
+		for _, key := range section.Keys() {
+			m[prefix+key.Name()] = key.Value()
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,41 @@
+package environ
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIniEncode(t *testing.T) {
+	out, er := Marshal("ini", map[string]string{
+		"name":             "app",
+		"database.user":    "admin",
+		"database.pass":    "p#ss; \"word\"",
+		"aws.default.key":  "multi\nline",
+		"database.comment": " padded ",
+	})
+	require.NoError(t, er)
+	assert.Equal(t, `name = app
+
+[aws.default]
+key = """multi
+line"""
+
+[database]
+comment = """ padded """
+pass = """p#ss; "word""""
+user = admin
+`, string(out))
+
+	_, er = Marshal("ini", map[string]string{"a": `x"""y`})
+	assert.Error(t, er)
+	_, er = Marshal("ini", map[string]string{"a=b": "x"})
+	assert.Error(t, er)
+}
+
+func TestIniDecode(t *testing.T) {
+	out, er := Unmarshal("ini", []byte("; comment\ntop = 1\n\n[db]\nuser = admin # inline comment\npass = `a#b`\n"))
+	require.NoError(t, er)
+	assert.Equal(t, map[string]string{"top": "1", "db.user": "admin", "db.pass": "a#b"}, out)
+}
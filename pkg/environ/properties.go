@@ -0,0 +1,164 @@
+package environ
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+func init() {
+	RegisterFormat("properties", encodeProperties, decodeProperties, "text/x-java-properties", ".properties")
+}
+
+// encodeProperties writes a key=value line per secret, sorted by key, escaped as java.util.Properties.store does so
+// the file is plain ASCII: newlines and tabs as \n and \t, separators and comment characters with a backslash, and
+// characters outside printable ASCII as \uXXXX
+func encodeProperties(w io.Writer, m map[string]string) error {
+	buf := bufio.NewWriter(w)
+	for _, k := range sortedNames(m) {
+		escapeProperty(buf, k, true)
+		buf.WriteByte('=')
+		escapeProperty(buf, m[k], false)
+		buf.WriteByte('\n')
+	}
+	return buf.Flush()
+}
+
+// escapeProperty writes s escaped for a properties file. Spaces are escaped throughout a key, but only at the start
+// of a value, where they would otherwise be skipped.
+func escapeProperty(buf *bufio.Writer, s string, key bool) {
+	for i, r := range s {
+		switch {
+		case r == ' ':
+			if key || i == 0 {
+				buf.WriteByte('\\')
+			}
+			buf.WriteByte(' ')
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\r':
+			buf.WriteString(`\r`)
+		case r == '\f':
+			buf.WriteString(`\f`)
+		case strings.ContainsRune(`\=:#!`, r):
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			for _, u := range utf16.Encode([]rune{r}) {
+				fmt.Fprintf(buf, `\u%04X`, u)
+			}
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
+
+// decodeProperties reads a properties file as java.util.Properties.load does, but as UTF-8: comments start with # or
+// !, a line ending in an odd number of backslashes continues on the next, and a key ends at the first unescaped =, :
+// or whitespace
+func decodeProperties(data []byte) (map[string]string, error) {
+	text := strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(string(data))
+	lines := strings.Split(text, "\n")
+
+	m := make(map[string]string)
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimLeft(lines[i], " \t\f")
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		for continues(line) && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + strings.TrimLeft(lines[i], " \t\f")
+		}
+		if continues(line) {
+			line = line[:len(line)-1]
+		}
+
+		end := len(line)
+		for j := 0; j < len(line); j++ {
+			if line[j] == '\\' {
+				j++
+			} else if strings.IndexByte("=: \t\f", line[j]) >= 0 {
+				end = j
+				break
+			}
+		}
+		value := strings.TrimLeft(line[end:], " \t\f")
+		if value != "" && (value[0] == '=' || value[0] == ':') {
+			value = strings.TrimLeft(value[1:], " \t\f")
+		}
+
+		k, er := unescapeProperty(line[:end])
+		if er != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, er)
+		}
+		v, er := unescapeProperty(value)
+		if er != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, er)
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+// continues returns whether the line ends in an odd number of backslashes
+func continues(line string) bool {
+	n := len(line) - len(strings.TrimRight(line, `\`))
+	return n%2 == 1
+}
+
+// unescapeProperty returns s with its escapes replaced, joining \uXXXX surrogate pairs
+func unescapeProperty(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+
+	var units []uint16
+	flush := func(b *bytes.Buffer) {
+		b.WriteString(string(utf16.Decode(units)))
+		units = nil
+	}
+	b := new(bytes.Buffer)
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			flush(b)
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if s[i] == 'u' {
+			if i+5 > len(s) {
+				return "", fmt.Errorf("malformed \\u escape in %q", s)
+			}
+			u, er := strconv.ParseUint(s[i+1:i+5], 16, 16)
+			if er != nil {
+				return "", fmt.Errorf("malformed \\u escape in %q", s)
+			}
+			units = append(units, uint16(u))
+			i += 4
+			continue
+		}
+
+		flush(b)
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	flush(b)
+	return b.String(), nil
+}
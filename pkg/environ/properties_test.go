@@ -0,0 +1,57 @@
+package environ
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPropertiesEncode(t *testing.T) {
+	out, er := Marshal("properties", map[string]string{
+		"db.url":   "jdbc:mysql://db:3306/app?a=b",
+		"key with": " lead\ntwo #!",
+		"unicode":  "é 😀",
+	})
+	require.NoError(t, er)
+	assert.Equal(t, `db.url=jdbc\:mysql\://db\:3306/app?a\=b
+key\ with=\ lead\ntwo \#\!
+unicode=\u00E9 \uD83D\uDE00
+`, string(out))
+}
+
+// TestPropertiesDecode reads a file as Java would write it by hand
+func TestPropertiesDecode(t *testing.T) {
+	in := "# comment\n" +
+		"! also a comment\n" +
+		"   spaced   =   value  \n" +
+		"colon:sep\n" +
+		"space sep\n" +
+		"multi = one, \\\n" +
+		"        two, \\\n" +
+		"        three\n" +
+		"escaped\\=key\\ name = tab\\there\r\n" +
+		"utf8 = pässwörd\n" +
+		"surrogate = \\uD83D\\uDE00 \\u00e9\n" +
+		"empty\n" +
+		"even = ends in \\\\\n" +
+		"next = line\n"
+
+	out, er := Unmarshal("properties", []byte(in))
+	require.NoError(t, er)
+	assert.Equal(t, map[string]string{
+		"spaced":           "value  ",
+		"colon":            "sep",
+		"space":            "sep",
+		"multi":            "one, two, three",
+		"escaped=key name": "tab\there",
+		"utf8":             "pässwörd",
+		"surrogate":        "😀 é",
+		"empty":            "",
+		"even":             `ends in \`,
+		"next":             "line",
+	}, out)
+
+	_, er = Unmarshal("properties", []byte(`bad = \u12`))
+	assert.Error(t, er)
+}
@@ -0,0 +1,234 @@
+package environ
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+// Policy determines how an Environ treats a provider which tries to set a protected name
+type Policy int
+
+const (
+	// PolicyRefuse drops protected names set by a provider
+	PolicyRefuse Policy = iota
+	// PolicyWarn allows protected names set by a provider, but logs each override
+	PolicyWarn
+	// PolicyAllow silently allows protected names set by a provider
+	PolicyAllow
+)
+
+// ProviderPolicySeparator is the separator between a provider name and its Policy in a policy spec
+const ProviderPolicySeparator = "="
+
+var (
+	protectedMu sync.RWMutex
+
+	// names which, if set by a secret provider, could hijack the exec'd process
+	protectedNames = map[string]struct{}{
+		"BASH_ENV":          {},
+		"DYLD_*":            {},
+		"ENV":               {},
+		"GCONV_PATH":        {},
+		"HOME":              {},
+		"HOSTALIASES":       {},
+		"IFS":               {},
+		"JAVA_TOOL_OPTIONS": {},
+		"LD_*":              {},
+		"LOCALDOMAIN":       {},
+		"MALLOC_*":          {},
+		"NODE_OPTIONS":      {},
+		"PATH":              {},
+		"PERL5LIB":          {},
+		"PERL5OPT":          {},
+		"PROMPT_COMMAND":    {},
+		"PYTHONPATH":        {},
+		"PYTHONSTARTUP":     {},
+		"RES_OPTIONS":       {},
+		"RUBYLIB":           {},
+		"RUBYOPT":           {},
+		"SHELL":             {},
+		"SHELLOPTS":         {},
+		"TMPDIR":            {},
+	}
+)
+
+// Protect adds names to the list of protected environment variable names. Names may be glob patterns as
+// understood by path.Match e.g. LD_*
+func Protect(names ...string) {
+	protectedMu.Lock()
+	defer protectedMu.Unlock()
+
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			protectedNames[strings.ToUpper(name)] = struct{}{}
+		}
+	}
+}
+
+// ProtectedNames returns a sorted list of all protected environment variable names
+func ProtectedNames() []string {
+	protectedMu.RLock()
+	defer protectedMu.RUnlock()
+
+	names := make([]string, 0, len(protectedNames))
+	for name := range protectedNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsProtected returns true if the given environment variable name is protected
+func IsProtected(name string) bool {
+	protectedMu.RLock()
+	defer protectedMu.RUnlock()
+
+	name = strings.ToUpper(name)
+	if _, ok := protectedNames[name]; ok {
+		return true
+	}
+	for pattern := range protectedNames {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePolicy returns the Policy with the given name or an error if it is unknown
+func ParsePolicy(s string) (Policy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "refuse", "deny":
+		return PolicyRefuse, nil
+	case "warn":
+		return PolicyWarn, nil
+	case "allow":
+		return PolicyAllow, nil
+	default:
+		return PolicyRefuse, fmt.Errorf("Unknown policy %s", s)
+	}
+}
+
+// String returns the name of the Policy
+func (p Policy) String() string {
+	switch p {
+	case PolicyWarn:
+		return "warn"
+	case PolicyAllow:
+		return "allow"
+	default:
+		return "refuse"
+	}
+}
+
+// SetPolicy sets the Policy applied to every provider without a provider specific Policy
+func (e *Environ) SetPolicy(p Policy) {
+	e.Lock()
+	defer e.Unlock()
+
+	e.policy = p
+}
+
+// SetProviderPolicy sets the Policy applied to the named provider
+func (e *Environ) SetProviderPolicy(provider string, p Policy) {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.policies == nil {
+		e.policies = make(map[string]Policy)
+	}
+	e.policies[provider] = p
+}
+
+// SetPolicies parses a list of policy specs in the form policy or provider=policy and applies them to this Environ
+func (e *Environ) SetPolicies(specs []string) error {
+	for _, spec := range specs {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+
+		bits := strings.SplitN(spec, ProviderPolicySeparator, 2)
+		if len(bits) == 1 {
+			p, er := ParsePolicy(bits[0])
+			if er != nil {
+				return er
+			}
+			e.SetPolicy(p)
+			continue
+		}
+
+		p, er := ParsePolicy(bits[1])
+		if er != nil {
+			return er
+		}
+		e.SetProviderPolicy(strings.TrimSpace(bits[0]), p)
+	}
+	return nil
+}
+
+// Policy returns the Policy applied to the named provider
+func (e *Environ) Policy(provider string) Policy {
+	e.RLock()
+	defer e.RUnlock()
+
+	return e.providerPolicy(provider)
+}
+
+func (e *Environ) providerPolicy(provider string) Policy {
+	if p, ok := e.policies[provider]; ok {
+		return p
+	}
+	return e.policy
+}
+
+// mergeProvider merges the secrets and Files gathered by the named provider into this Environ without overwriting
+// keys, applying the provider's Policy to any protected names
+func (e *Environ) mergeProvider(provider string, staged *Environ) {
+	m, files := staged.raw(), staged.Files()
+
+	e.Lock()
+	defer e.Unlock()
+
+	policy := e.providerPolicy(provider)
+	for _, f := range files {
+		if _, ok := e.files[f.Var]; ok {
+			continue
+		}
+		if key := e.normalize(f.Var); IsProtected(key) && !protectedAllowed(policy, provider, key) {
+			continue
+		}
+		e.addFile(f)
+	}
+
+	for k, v := range m {
+		if _, ok := e.m[k]; ok {
+			continue
+		}
+		if key := e.normalize(k); IsProtected(key) && !protectedAllowed(policy, provider, key) {
+			continue
+		}
+		e.m[k] = v
+		if e.sources == nil {
+			e.sources = make(map[string]string)
+		}
+		e.sources[k] = provider
+	}
+}
+
+// protectedAllowed returns false if policy refuses the provider setting the protected key, logging refusals and
+// the overrides it warns about
+func protectedAllowed(policy Policy, provider, key string) bool {
+	switch policy {
+	case PolicyRefuse:
+		log.Warnf("Refusing to override protected name. provider=%s key=%s", provider, key)
+		return false
+	case PolicyWarn:
+		log.Warnf("Overriding protected name. provider=%s key=%s", provider, key)
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+package environ
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+type mapProvider map[string]string
+
+func (mp mapProvider) AddToEnviron(e *Environ) error {
+	e.SafeMerge(mp)
+	return nil
+}
+
+func TestIsProtected(t *testing.T) {
+	tt := []struct {
+		name      string
+		protected bool
+	}{
+		{"PATH", true},
+		{"path", true},
+		{"LD_PRELOAD", true},
+		{"LD_LIBRARY_PATH", true},
+		{"BASH_ENV", true},
+		{"DATABASE_URL", false},
+		{"MY_PATH", false},
+	}
+
+	for _, test := range tt {
+		assert.Equalf(t, test.protected, IsProtected(test.name), test.name)
+	}
+}
+
+func TestPopulatePolicy(t *testing.T) {
+	RegisterProvider("protect-test", func() (Provider, error) {
+		return mapProvider{"PATH": "/tmp/evil", "ld_preload": "/tmp/evil.so", "DB_PASSWORD": "hunter2"}, nil
+	})
+
+	tt := []struct {
+		specs []string
+		keys  map[string]bool
+	}{
+		{[]string{"refuse"}, map[string]bool{"PATH": false, "LD_PRELOAD": false, "DB_PASSWORD": true}},
+		{[]string{"refuse", "protect-test=warn"}, map[string]bool{"PATH": true, "LD_PRELOAD": true, "DB_PASSWORD": true}},
+		{[]string{"allow", "other=refuse"}, map[string]bool{"PATH": true, "LD_PRELOAD": true, "DB_PASSWORD": true}},
+	}
+
+	for i, test := range tt {
+		e := New()
+		assert.NoError(t, e.SetPolicies(test.specs))
+		e.Populate([]string{"protect-test"})
+
+		m := e.Map()
+		for k, ok := range test.keys {
+			_, found := m[k]
+			assert.Equalf(t, ok, found, "%d: key=%s specs=%v", i, k, test.specs)
+		}
+	}
+
+	assert.Error(t, New().SetPolicies([]string{"bogus"}))
+}
+
+type slowProvider struct {
+	mapProvider
+	delay time.Duration
+}
+
+func (sp slowProvider) AddToEnviron(e *Environ) error {
+	time.Sleep(sp.delay)
+	return sp.mapProvider.AddToEnviron(e)
+}
+
+func TestPopulateOrder(t *testing.T) {
+	RegisterProvider("order-slow", func() (Provider, error) {
+		return slowProvider{mapProvider{"SHARED": "slow", "SLOW": "1"}, 20 * time.Millisecond}, nil
+	})
+	RegisterProvider("order-fast", func() (Provider, error) {
+		return mapProvider{"SHARED": "fast", "FAST": "1"}, nil
+	})
+
+	e := New()
+	assert.NoError(t, e.Populate([]string{"order-slow", "order-fast"}))
+	assert.Equal(t, map[string]string{"SHARED": "slow", "SLOW": "1", "FAST": "1"}, e.Map())
+	assert.Equal(t, "order-slow", e.Sources()["SHARED"])
+
+	e = New()
+	assert.NoError(t, e.Populate([]string{"order-fast", "order-slow"}))
+	assert.Equal(t, "fast", e.Map()["SHARED"])
+}
+
+// warnLogger records warnings
+type warnLogger struct {
+	log.Logger
+	warnings []string
+}
+
+func (wl *warnLogger) Warnf(f string, o ...interface{}) {
+	wl.warnings = append(wl.warnings, fmt.Sprintf(f, o...))
+}
+
+func TestPopulatePolicyLogs(t *testing.T) {
+	RegisterProvider("protect-log", func() (Provider, error) {
+		return mapProvider{"PATH": "/tmp/evil"}, nil
+	})
+	RegisterProvider("protect-files", func() (Provider, error) {
+		return fileProvider{{Var: "LD_PRELOAD", Content: "evil"}, {Var: "TLS_KEY", Content: "key"}}, nil
+	})
+	defer log.SetLogger(log.GetLogger())
+
+	tt := []struct {
+		spec     string
+		files    []string
+		warnings []string
+	}{
+		{"refuse", []string{"TLS_KEY"}, []string{
+			"Refusing to override protected name. provider=protect-log key=PATH",
+			"Refusing to override protected name. provider=protect-files key=LD_PRELOAD",
+		}},
+		{"warn", []string{"LD_PRELOAD", "TLS_KEY"}, []string{
+			"Overriding protected name. provider=protect-log key=PATH",
+			"Overriding protected name. provider=protect-files key=LD_PRELOAD",
+		}},
+		{"allow", []string{"LD_PRELOAD", "TLS_KEY"}, nil},
+	}
+
+	for _, test := range tt {
+		wl := &warnLogger{Logger: log.NewNilLogger()}
+		log.SetLogger(wl)
+
+		e := New()
+		assert.NoError(t, e.SetPolicies([]string{test.spec}))
+		e.Populate([]string{"protect-log", "protect-files"})
+
+		var files []string
+		for _, f := range e.Files() {
+			files = append(files, f.Var)
+		}
+		assert.Equalf(t, test.files, files, test.spec)
+		assert.ElementsMatchf(t, test.warnings, wl.warnings, test.spec)
+	}
+}
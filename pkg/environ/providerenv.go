@@ -0,0 +1,61 @@
+package environ
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+// ProviderEnv lets providers be populated more than once. Providers unset their configuration from the environment
+// once read, so Populate records what they unset and puts it back before populating again.
+type ProviderEnv struct {
+	sync.Mutex
+	consumed map[string]string
+}
+
+// Populate populates e from the providers, restoring the provider configuration consumed by earlier calls
+func (pe *ProviderEnv) Populate(e *Environ, providers []string) error {
+	pe.Lock()
+	defer pe.Unlock()
+
+	pe.restore()
+	before := environMap()
+	er := e.Populate(providers)
+	for k := range environMap() {
+		delete(before, k)
+	}
+
+	if pe.consumed == nil {
+		pe.consumed = make(map[string]string, len(before))
+	}
+	for k, v := range before {
+		pe.consumed[k] = v
+	}
+	return er
+}
+
+// Restore puts back the provider configuration consumed by Populate, for a provider client created
+// outside of Populate
+func (pe *ProviderEnv) Restore() {
+	pe.Lock()
+	defer pe.Unlock()
+	pe.restore()
+}
+
+func (pe *ProviderEnv) restore() {
+	for k, v := range pe.consumed {
+		os.Setenv(k, v)
+	}
+}
+
+// environMap returns os.Environ as a map
+func environMap() map[string]string {
+	items := os.Environ()
+	m := make(map[string]string, len(items))
+	for _, item := range items {
+		if bits := strings.SplitN(item, "=", 2); len(bits) == 2 {
+			m[bits[0]] = bits[1]
+		}
+	}
+	return m
+}
@@ -0,0 +1,37 @@
+package environ
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// unsettingProvider reads its token from the environment and unsets it, as the real providers do
+type unsettingProvider struct{}
+
+func (unsettingProvider) AddToEnviron(e *Environ) error {
+	e.SafeMerge(map[string]string{"TOKEN_SEEN": os.Getenv("PROVIDERENV_TEST_TOKEN")})
+	os.Unsetenv("PROVIDERENV_TEST_TOKEN")
+	return nil
+}
+
+func TestProviderEnvPopulate(t *testing.T) {
+	RegisterProvider("providerenv-test", func() (Provider, error) { return unsettingProvider{}, nil })
+	defer delete(providers, "providerenv-test")
+	os.Setenv("PROVIDERENV_TEST_TOKEN", "s3cret")
+	defer os.Unsetenv("PROVIDERENV_TEST_TOKEN")
+
+	var pe ProviderEnv
+	for i := 0; i < 3; i++ {
+		e := New()
+		assert.NoError(t, pe.Populate(e, []string{"providerenv-test"}))
+		assert.Equalf(t, map[string]string{"TOKEN_SEEN": "s3cret"}, e.Map(), "populate %d", i)
+
+		_, ok := os.LookupEnv("PROVIDERENV_TEST_TOKEN")
+		assert.Falsef(t, ok, "populate %d leaves the configuration unset", i)
+	}
+
+	pe.Restore()
+	assert.Equal(t, "s3cret", os.Getenv("PROVIDERENV_TEST_TOKEN"))
+}
@@ -0,0 +1,48 @@
+package bundle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	env "github.com/caarlos0/env/v5"
+	"github.com/lumoslabs/vestibule/pkg/environ"
+)
+
+func init() {
+	environ.RegisterDoctor(Name, Diagnose)
+}
+
+// Diagnose checks that the key loads, and that the bundle exists, decrypts with it and is young enough
+func Diagnose() []environ.Check {
+	d := new(Decoder)
+	if er := env.Parse(d); er != nil {
+		return []environ.Check{{Step: "config", Err: er, Hint: fmt.Sprintf("check %s", MaxAgeEnvVar)}}
+	}
+	if d.File == "" || d.Key == "" {
+		return []environ.Check{{Step: "config", Err: fmt.Errorf("%s and %s are required", FileEnvVar, KeyEnvVar), Hint: "set both"}}
+	}
+
+	key, er := LoadKey(d.Key)
+	if er != nil {
+		return []environ.Check{{Step: "key", Err: er, Hint: fmt.Sprintf("check %s names a readable file or a user key in the keyring", KeyEnvVar)}}
+	}
+	checks := []environ.Check{{Step: "key"}}
+
+	data, er := ioutil.ReadFile(d.File)
+	if er != nil {
+		return append(checks, environ.Check{Step: "file " + d.File, Err: er, Hint: "check that bule has written the bundle and it is mounted here"})
+	}
+	secrets, created, er := Open(key, data)
+	if er != nil {
+		return append(checks, environ.Check{Step: "file " + d.File, Err: er, Hint: "check that bule wrote the bundle with the same key"})
+	}
+
+	age := time.Since(created).Round(time.Second)
+	check := environ.Check{Step: "file " + d.File, Detail: fmt.Sprintf("keys=%d age=%v", len(secrets), age)}
+	if d.MaxAge > 0 && age > d.MaxAge {
+		check.Err = fmt.Errorf("bundle is %v old, older than %v", age, d.MaxAge)
+		check.Hint = fmt.Sprintf("check that bule is rewriting the bundle, or raise %s", MaxAgeEnvVar)
+	}
+	return append(checks, check)
+}
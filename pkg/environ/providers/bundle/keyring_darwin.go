@@ -0,0 +1,10 @@
+package bundle
+
+import (
+	"errors"
+)
+
+// readKeyring is not supported, as macOS has no kernel keyring
+func readKeyring(name string) ([]byte, error) {
+	return nil, errors.New("the kernel keyring is only available on linux")
+}
@@ -0,0 +1,30 @@
+package bundle
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// readKeyring returns the payload of the user key with the description name, searching the session keyring and the
+// user keyring
+func readKeyring(name string) ([]byte, error) {
+	id, er := unix.KeyctlSearch(unix.KEY_SPEC_SESSION_KEYRING, "user", name, 0)
+	if er != nil {
+		if id, er = unix.KeyctlSearch(unix.KEY_SPEC_USER_KEYRING, "user", name, 0); er != nil {
+			return nil, er
+		}
+	}
+
+	size, er := unix.KeyctlBuffer(unix.KEYCTL_READ, id, nil, 0)
+	if er != nil {
+		return nil, er
+	}
+	buf := make([]byte, size)
+	n, er := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0)
+	if er != nil {
+		return nil, er
+	}
+	if n < len(buf) {
+		buf = buf[:n]
+	}
+	return buf, nil
+}
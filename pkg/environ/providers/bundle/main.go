@@ -0,0 +1,176 @@
+package bundle
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	env "github.com/caarlos0/env/v5"
+	"github.com/lumoslabs/vestibule/pkg/environ"
+	"github.com/lumoslabs/vestibule/pkg/log"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	// Name is the Provider name
+	Name = "bundle"
+
+	// FileEnvVar is the environment variable holding the path of the bundle
+	FileEnvVar = "BUNDLE_FILE"
+
+	// KeyEnvVar is the environment variable holding where the key of the bundle is
+	KeyEnvVar = "BUNDLE_KEY"
+
+	// MaxAgeEnvVar is the environment variable holding the oldest bundle accepted
+	MaxAgeEnvVar = "BUNDLE_MAX_AGE"
+
+	// KeySize is the size of a bundle key in bytes
+	KeySize = 32
+
+	// KeyringPrefix marks a key in the kernel keyring rather than in a file
+	KeyringPrefix = "keyring:"
+
+	nonceSize = 24
+)
+
+// header starts every bundle, naming its format
+var header = []byte("vestibule-bundle-v1\n")
+
+var (
+	// ErrNotBundle is returned when the data does not start with the bundle header
+	ErrNotBundle = errors.New("not a vestibule bundle")
+	// ErrDecrypt is returned when the bundle does not decrypt with the key, or was tampered with
+	ErrDecrypt = errors.New("bundle does not decrypt with the key")
+)
+
+func init() {
+	environ.RegisterProvider(Name, New)
+}
+
+// New returns a Decoder as an environ.Provider or an error if configuring failed
+func New() (environ.Provider, error) {
+	defer func() {
+		os.Unsetenv(FileEnvVar)
+		os.Unsetenv(KeyEnvVar)
+		os.Unsetenv(MaxAgeEnvVar)
+	}()
+
+	d := new(Decoder)
+	if er := env.Parse(d); er != nil {
+		return nil, er
+	}
+	if d.File == "" || d.Key == "" {
+		return nil, fmt.Errorf("%s and %s are required", FileEnvVar, KeyEnvVar)
+	}
+	return d, nil
+}
+
+// AddToEnviron decrypts the bundle, checks its age and merges its secrets into the environ.Environ
+func (d *Decoder) AddToEnviron(e *environ.Environ) error {
+	e.Delete(FileEnvVar)
+	e.Delete(KeyEnvVar)
+	e.Delete(MaxAgeEnvVar)
+
+	key, er := LoadKey(d.Key)
+	if er != nil {
+		return er
+	}
+	data, er := ioutil.ReadFile(d.File)
+	if er != nil {
+		return er
+	}
+	secrets, created, er := Open(key, data)
+	if er != nil {
+		return fmt.Errorf("failed to open %s: %v", d.File, er)
+	}
+
+	age := time.Since(created)
+	log.Debugf("Opened bundle. file=%s created=%s age=%v", d.File, created.Format(time.RFC3339), age)
+	if d.MaxAge > 0 && age > d.MaxAge {
+		return fmt.Errorf("bundle %s is %v old, older than %v", d.File, age.Round(time.Second), d.MaxAge)
+	}
+	e.SafeMerge(secrets)
+	return nil
+}
+
+// Seal encrypts the secrets into a bundle with NaCl secretbox, stamped with the current time
+func Seal(key *[KeySize]byte, secrets map[string]string) ([]byte, error) {
+	plain, er := json.Marshal(contents{Created: time.Now().UTC(), Secrets: secrets})
+	if er != nil {
+		return nil, er
+	}
+
+	var nonce [nonceSize]byte
+	if _, er := rand.Read(nonce[:]); er != nil {
+		return nil, er
+	}
+	out := append(append([]byte{}, header...), nonce[:]...)
+	return secretbox.Seal(out, plain, &nonce, key), nil
+}
+
+// Open decrypts a bundle, returning its secrets and when it was sealed
+func Open(key *[KeySize]byte, data []byte) (map[string]string, time.Time, error) {
+	if !bytes.HasPrefix(data, header) {
+		return nil, time.Time{}, ErrNotBundle
+	}
+	data = data[len(header):]
+	if len(data) < nonceSize+secretbox.Overhead {
+		return nil, time.Time{}, ErrDecrypt
+	}
+
+	var nonce [nonceSize]byte
+	copy(nonce[:], data)
+	plain, ok := secretbox.Open(nil, data[nonceSize:], &nonce, key)
+	if !ok {
+		return nil, time.Time{}, ErrDecrypt
+	}
+
+	var c contents
+	if er := json.Unmarshal(plain, &c); er != nil {
+		return nil, time.Time{}, er
+	}
+	return c.Secrets, c.Created, nil
+}
+
+// LoadKey returns the key from a file, or from the kernel keyring when prefixed with KeyringPrefix. The key is 32
+// bytes, raw or hex or base64 encoded.
+func LoadKey(spec string) (*[KeySize]byte, error) {
+	var (
+		data []byte
+		er   error
+	)
+	if name := strings.TrimPrefix(spec, KeyringPrefix); name != spec {
+		data, er = readKeyring(name)
+	} else {
+		data, er = ioutil.ReadFile(spec)
+	}
+	if er != nil {
+		return nil, fmt.Errorf("failed to read bundle key %s: %v", spec, er)
+	}
+	return decodeKey(data)
+}
+
+func decodeKey(data []byte) (*[KeySize]byte, error) {
+	var key [KeySize]byte
+	if len(data) == KeySize {
+		copy(key[:], data)
+		return &key, nil
+	}
+
+	text := strings.TrimSpace(string(data))
+	for _, decode := range []func(string) ([]byte, error){hex.DecodeString, base64.StdEncoding.DecodeString, base64.URLEncoding.DecodeString} {
+		if b, er := decode(text); er == nil && len(b) == KeySize {
+			copy(key[:], b)
+			return &key, nil
+		}
+	}
+	return nil, fmt.Errorf("bundle key is not %d bytes, raw or hex or base64 encoded", KeySize)
+}
@@ -0,0 +1,111 @@
+package bundle
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newKey(t *testing.T) *[KeySize]byte {
+	var key [KeySize]byte
+	_, er := rand.Read(key[:])
+	require.NoError(t, er)
+	return &key
+}
+
+func TestIsProvider(t *testing.T) {
+	assert.Implements(t, (*environ.Provider)(nil), new(Decoder))
+}
+
+func TestSealOpen(t *testing.T) {
+	key := newKey(t)
+	secrets := map[string]string{"PEM": "-----BEGIN KEY-----\nabc\n-----END KEY-----\n", "UNICODE": "pässwörd ✓"}
+
+	data, er := Seal(key, secrets)
+	require.NoError(t, er)
+	assert.NotContains(t, string(data), "pässwörd")
+
+	opened, created, er := Open(key, data)
+	require.NoError(t, er)
+	assert.Equal(t, secrets, opened)
+	assert.WithinDuration(t, time.Now(), created, time.Minute)
+
+	_, _, er = Open(newKey(t), data)
+	assert.Equal(t, ErrDecrypt, er, "wrong key")
+
+	data[len(data)-1] ^= 1
+	_, _, er = Open(key, data)
+	assert.Equal(t, ErrDecrypt, er, "tampered")
+
+	_, _, er = Open(key, []byte("{}"))
+	assert.Equal(t, ErrNotBundle, er)
+}
+
+func TestDecodeKey(t *testing.T) {
+	key := newKey(t)
+	for _, data := range [][]byte{
+		key[:],
+		[]byte(hex.EncodeToString(key[:]) + "\n"),
+		[]byte(base64.StdEncoding.EncodeToString(key[:])),
+		[]byte(base64.URLEncoding.EncodeToString(key[:])),
+	} {
+		decoded, er := decodeKey(data)
+		if assert.NoError(t, er) {
+			assert.Equal(t, key, decoded)
+		}
+	}
+
+	_, er := decodeKey([]byte("too short"))
+	assert.Error(t, er)
+}
+
+func TestAddToEnviron(t *testing.T) {
+	dir, er := ioutil.TempDir("", "bundle")
+	require.NoError(t, er)
+	defer os.RemoveAll(dir)
+
+	key := newKey(t)
+	keyFile, bundleFile := dir+"/key", dir+"/app.bundle"
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte(hex.EncodeToString(key[:])), 0600))
+	data, er := Seal(key, map[string]string{"FOO": "bar"})
+	require.NoError(t, er)
+	require.NoError(t, ioutil.WriteFile(bundleFile, data, 0600))
+
+	tests := []struct {
+		name, maxAge string
+		ok           bool
+	}{
+		{"no-max-age", "", true},
+		{"young", "1h", true},
+		{"too-old", "1ns", false},
+	}
+	for _, tt := range tests {
+		os.Setenv(FileEnvVar, bundleFile)
+		os.Setenv(KeyEnvVar, keyFile)
+		os.Setenv(MaxAgeEnvVar, tt.maxAge)
+
+		d, er := New()
+		require.NoErrorf(t, er, tt.name)
+		_, set := os.LookupEnv(KeyEnvVar)
+		assert.Falsef(t, set, "%s: configuration unset", tt.name)
+
+		e := environ.New()
+		er = d.AddToEnviron(e)
+		if tt.ok {
+			assert.NoErrorf(t, er, tt.name)
+			v, _ := e.Load("FOO")
+			assert.Equalf(t, "bar", v, tt.name)
+		} else {
+			assert.Errorf(t, er, tt.name)
+			assert.Equalf(t, 0, e.Len(), tt.name)
+		}
+	}
+}
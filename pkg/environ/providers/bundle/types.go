@@ -0,0 +1,30 @@
+package bundle
+
+import (
+	"time"
+)
+
+// EnvVars is a map of known vonfiguration environment variables and their usage descriptions
+var EnvVars = map[string]string{
+	FileEnvVar: `Path of an encrypted bundle written by bule --format=bundle. The bundle is decrypted with the key from
+BUNDLE_KEY and its secrets injected into Environ.
+e.g. BUNDLE_FILE=/var/run/secrets/app.bundle`,
+	KeyEnvVar: `Key of the bundle: a file holding 32 bytes, raw or hex or base64 encoded, or keyring:NAME for a user key
+in the kernel keyring.
+e.g. BUNDLE_KEY=keyring:app-bundle`,
+	MaxAgeEnvVar: `Refuse bundles written longer ago than this duration. Unset or 0 accepts any age.
+e.g. BUNDLE_MAX_AGE=1h`,
+}
+
+// Decoder is an environ.Provider which decrypts a bundle of secrets written by bule
+type Decoder struct {
+	File   string        `env:"BUNDLE_FILE"`
+	Key    string        `env:"BUNDLE_KEY"`
+	MaxAge time.Duration `env:"BUNDLE_MAX_AGE"`
+}
+
+// contents is the sealed part of a bundle
+type contents struct {
+	Created time.Time         `json:"created"`
+	Secrets map[string]string `json:"secrets"`
+}
@@ -0,0 +1,46 @@
+package dotenv
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/joho/godotenv"
+
+	env "github.com/caarlos0/env/v5"
+	"github.com/lumoslabs/vestibule/pkg/environ"
+)
+
+func init() {
+	environ.RegisterDoctor(Name, Diagnose)
+}
+
+// Diagnose checks that every dotenv file exists and parses
+func Diagnose() []environ.Check {
+	var de = &Parser{}
+	if er := env.Parse(de); er != nil {
+		return []environ.Check{{Step: "config", Err: er, Hint: fmt.Sprintf("check %s", FilesEnvVar)}}
+	}
+	if len(de.Files) == 0 {
+		de.Files = findDotenvFiles()
+	}
+	if len(de.Files) == 0 {
+		return []environ.Check{{Step: "config", Err: fmt.Errorf("no dotenv files found"), Hint: fmt.Sprintf("set %s or run from a directory with .env files", FilesEnvVar)}}
+	}
+
+	checks := make([]environ.Check, 0, len(de.Files))
+	for _, f := range de.Files {
+		check := environ.Check{Step: "file " + f}
+		if m, er := godotenv.Read(f); er != nil {
+			check.Err = er
+			if os.IsNotExist(er) {
+				check.Hint = fmt.Sprintf("check the path in %s, or mount the file", FilesEnvVar)
+			} else {
+				check.Hint = "the file is not valid dotenv"
+			}
+		} else {
+			check.Detail = fmt.Sprintf("keys=%d", len(m))
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
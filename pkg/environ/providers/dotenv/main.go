@@ -65,7 +65,7 @@ func findDotenvFiles() []string {
 		case path != cwd && info.IsDir():
 			return filepath.SkipDir
 		case filepath.Ext(path) == ".env":
-			files = append(files, filepath.Join(cwd, path))
+			files = append(files, path)
 		}
 		return nil
 	})
@@ -0,0 +1,73 @@
+package ejson
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/Shopify/ejson"
+	ejJson "github.com/Shopify/ejson/json"
+	"github.com/lumoslabs/vestibule/pkg/environ"
+)
+
+func init() {
+	environ.RegisterDoctor(Name, Diagnose)
+}
+
+// Diagnose checks that every ejson file exists, names a public key with a private key in KeysEnvVar, and decrypts
+// with it
+func Diagnose() []environ.Check {
+	d, er := newDecoder()
+	if er != nil {
+		return []environ.Check{{Step: "config", Err: er, Hint: fmt.Sprintf("check %s and %s", FilesEnvVar, KeysEnvVar)}}
+	}
+	if len(d.Files) == 0 {
+		return []environ.Check{{Step: "config", Err: fmt.Errorf("no ejson files found"), Hint: fmt.Sprintf("set %s or run from a directory with .ejson files", FilesEnvVar)}}
+	}
+
+	checks := make([]environ.Check, 0, len(d.Files))
+	for _, f := range d.Files {
+		checks = append(checks, checkFile(f, d.KeyPairs))
+	}
+	return checks
+}
+
+func checkFile(path string, kpm KeyPairMap) environ.Check {
+	check := environ.Check{Step: "file " + path}
+
+	data, er := ioutil.ReadFile(path)
+	if er != nil {
+		check.Err = er
+		if os.IsNotExist(er) {
+			check.Hint = fmt.Sprintf("check the path in %s, or mount the file", FilesEnvVar)
+		}
+		return check
+	}
+
+	doc := make(map[string]interface{})
+	if er := json.Unmarshal(data, &doc); er != nil {
+		check.Err, check.Hint = er, "the file is not valid json"
+		return check
+	}
+	pubkey, _ := doc[ejJson.PublicKeyField].(string)
+	if pubkey == "" {
+		check.Err = fmt.Errorf("no %s field", ejJson.PublicKeyField)
+		check.Hint = "add the public key the file is encrypted for, then run ejson encrypt"
+		return check
+	}
+
+	privkey, ok := kpm[pubkey]
+	if !ok {
+		check.Err = fmt.Errorf("no private key for public key %s", pubkey)
+		check.Hint = fmt.Sprintf("add %s%s<private key> to %s", pubkey, KeyPairSeparator, KeysEnvVar)
+		return check
+	}
+	if _, er := ejson.DecryptFile(path, os.TempDir(), privkey); er != nil {
+		check.Err = er
+		check.Hint = fmt.Sprintf("the private key for %s in %s does not decrypt the file, check the pair", pubkey, KeysEnvVar)
+		return check
+	}
+	check.Detail = fmt.Sprintf("public_key=%s", pubkey)
+	return check
+}
@@ -44,6 +44,15 @@ func New() (environ.Provider, error) {
 		os.Unsetenv(KeysEnvVar)
 	}()
 
+	d, er := newDecoder()
+	if er != nil {
+		return nil, er
+	}
+	return d, nil
+}
+
+// newDecoder returns a Decoder configured from the environment
+func newDecoder() (*Decoder, error) {
 	var d = new(Decoder)
 	p := env.CustomParsers{reflect.TypeOf(KeyPairMap{}): keyPairMapParser}
 	if er := env.ParseWithFuncs(d, p); er != nil {
@@ -83,3 +83,39 @@ func TestAddToEnviron(t *testing.T) {
 		}
 	}
 }
+
+func TestDiagnose(t *testing.T) {
+	tests := []struct {
+		name, doc, keys, err string
+	}{
+		{"good", `{"_public_key": "a04086f26d0a6b01a9ca7954b60c4de7517070da7940e698b9250e124042eb29","TEST_KEY": "EJ[1:CWMhGji3q8i0vGCGnLI4jHScp2lXA/VjETOtNBEsXB4=:CFpXDOdnEhsVXvd5tabbUcDlilzpSgc8:IBq+xoe33AnbCljM1cdY1y44ISW5VIIdE6s=]"}`, keys[0], ""},
+		{"unknown-key", `{"_public_key": "a04086f26d0a6b01a9ca7954b60c4de7517070da7940e698b9250e124042eb29"}`, keys[1], "no private key for public key a04086f26d0a6b01a9ca7954b60c4de7517070da7940e698b9250e124042eb29"},
+		{"wrong-key", `{"_public_key": "a04086f26d0a6b01a9ca7954b60c4de7517070da7940e698b9250e124042eb29","TEST_KEY": "EJ[1:CWMhGji3q8i0vGCGnLI4jHScp2lXA/VjETOtNBEsXB4=:CFpXDOdnEhsVXvd5tabbUcDlilzpSgc8:IBq+xoe33AnbCljM1cdY1y44ISW5VIIdE6s=]"}`, "a04086f26d0a6b01a9ca7954b60c4de7517070da7940e698b9250e124042eb29;3f25827973d60dc3ebdd1f6e6cb5560b5d2e29f7b4de7abac648b69a334d9e48", "couldn't decrypt message"},
+		{"no-public-key", `{"TEST_KEY": "value"}`, keys[0], "no _public_key field"},
+	}
+
+	for _, tt := range tests {
+		f, _ := afero.TempFile(fs, "", "")
+		f.WriteString(tt.doc)
+		f.Close()
+
+		os.Setenv(KeysEnvVar, tt.keys)
+		os.Setenv(FilesEnvVar, f.Name())
+
+		checks := Diagnose()
+		assert.Lenf(t, checks, 1, tt.name)
+		if tt.err == "" {
+			assert.NoErrorf(t, checks[0].Err, tt.name)
+		} else if assert.Errorf(t, checks[0].Err, tt.name) {
+			assert.Containsf(t, checks[0].Err.Error(), tt.err, tt.name)
+			assert.NotEmptyf(t, checks[0].Hint, tt.name)
+		}
+		fs.Remove(f.Name())
+	}
+
+	os.Setenv(FilesEnvVar, "/nonexistent.ejson")
+	checks := Diagnose()
+	assert.True(t, os.IsNotExist(checks[0].Err))
+	os.Unsetenv(FilesEnvVar)
+	os.Unsetenv(KeysEnvVar)
+}
@@ -0,0 +1,109 @@
+package sops
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+)
+
+// keyFields are the sops metadata key groups and the field naming each key in them
+var keyFields = map[string]string{
+	"kms":      "arn",
+	"gcp_kms":  "resource_id",
+	"azure_kv": "vault_url",
+	"pgp":      "fp",
+}
+
+func init() {
+	environ.RegisterDoctor(Name, Diagnose)
+}
+
+// Diagnose checks that every sops file exists, carries sops metadata, and decrypts with the keys available here
+func Diagnose() []environ.Check {
+	d, er := newDecoder()
+	if er != nil {
+		return []environ.Check{{Step: "config", Err: er, Hint: fmt.Sprintf("check %s, files need a .yaml, .json or .env extension", FilesEnvVar)}}
+	}
+	if len(d.Files) == 0 {
+		return []environ.Check{{Step: "config", Err: fmt.Errorf("no sops files configured"), Hint: fmt.Sprintf("set %s", FilesEnvVar)}}
+	}
+
+	checks := make([]environ.Check, 0, len(d.Files))
+	for i := range d.Files {
+		checks = append(checks, checkFile(&d.Files[i]))
+	}
+	return checks
+}
+
+func checkFile(ef *EncryptedFile) environ.Check {
+	check := environ.Check{Step: "file " + ef.Path}
+
+	data, er := ioutil.ReadFile(ef.Path)
+	if er != nil {
+		check.Err = er
+		if os.IsNotExist(er) {
+			check.Hint = fmt.Sprintf("check the path in %s, or mount the file", FilesEnvVar)
+		}
+		return check
+	}
+
+	keys := keySources(ef.Ext, data)
+	if len(keys) == 0 {
+		check.Err = fmt.Errorf("no sops keys in file metadata")
+		check.Hint = "the file is not encrypted with sops, or was encrypted only with a key type this check cannot list"
+		return check
+	}
+	if _, er := ef.Decrypt(); er != nil {
+		check.Err = er
+		check.Hint = fmt.Sprintf("make one of these keys available here: %s", strings.Join(keys, ", "))
+		return check
+	}
+	check.Detail = fmt.Sprintf("keys=%s", strings.Join(keys, ","))
+	return check
+}
+
+// keySources returns the keys the file is encrypted for, as type:name, from its sops metadata
+func keySources(ext string, data []byte) []string {
+	var keys []string
+	if ext == "dotenv" {
+		m, er := godotenv.Unmarshal(string(data))
+		if er != nil {
+			return nil
+		}
+		for k, v := range m {
+			for group, field := range keyFields {
+				if strings.HasPrefix(k, "sops_"+group+"__list_") && strings.HasSuffix(k, "__map_"+field) {
+					keys = append(keys, group+":"+v)
+				}
+			}
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	var doc struct {
+		Sops map[string]interface{} `yaml:"sops"`
+	}
+	if er := yaml.Unmarshal(data, &doc); er != nil {
+		return nil
+	}
+	for group, field := range keyFields {
+		list, _ := doc.Sops[group].([]interface{})
+		for _, item := range list {
+			if key, ok := item.(map[interface{}]interface{}); ok {
+				if v, ok := key[field].(string); ok {
+					keys = append(keys, group+":"+v)
+				}
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -41,6 +41,11 @@ func init() {
 // New returns a Decoder object as an environ.Environ or an error if configuring failed.
 func New() (environ.Provider, error) {
 	defer func() { os.Unsetenv(FilesEnvVar) }()
+	return newDecoder()
+}
+
+// newDecoder returns a Decoder configured from the environment
+func newDecoder() (*Decoder, error) {
 	var (
 		d = &Decoder{}
 		p = env.CustomParsers{reflect.TypeOf(EncryptedFile{}): encryptedFileParser}
@@ -54,7 +59,8 @@ func New() (environ.Provider, error) {
 func (d *Decoder) AddToEnviron(e *environ.Environ) error {
 	os.Unsetenv(FilesEnvVar)
 	e.Delete(FilesEnvVar)
-	for _, f := range d.Files {
+	for i := range d.Files {
+		f := &d.Files[i]
 		data, er := f.Decrypt()
 		if er != nil {
 			return er
@@ -117,7 +123,7 @@ func encryptedFileParser(s string) (interface{}, error) {
 		ef.OutputPath = bits[1]
 	}
 
-	switch filepath.Ext(s) {
+	switch filepath.Ext(ef.Path) {
 	case ".yaml", ".yml":
 		ef.Ext = "yaml"
 		ef.UnmarshalFunc = yaml.Unmarshal
@@ -131,7 +137,7 @@ func encryptedFileParser(s string) (interface{}, error) {
 		return nil, fmt.Errorf("Unknown file type: %s", s)
 	}
 
-	return &ef, nil
+	return ef, nil
 }
 
 func envUmarshalFunc(d []byte, m interface{}) error {
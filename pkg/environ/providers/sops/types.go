@@ -14,7 +14,7 @@ e.g. SOPS_FILES=/path/to/file[;/path/to/output[;mode]]:...`,
 
 // Decoder is an environ.Provider which accepts a list of files encrypted with github.com/mozilla/sops
 type Decoder struct {
-	Files []*EncryptedFile `env:"SOPS_FILES" envSeparator:":"`
+	Files []EncryptedFile `env:"SOPS_FILES" envSeparator:":"`
 }
 
 // EncryptedFile is a file that has been encrypted with github.com/mozilla/sops
@@ -0,0 +1,117 @@
+package vault
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	util "github.com/Masterminds/goutils"
+	"github.com/hashicorp/vault/api"
+	"github.com/lumoslabs/vestibule/pkg/environ"
+)
+
+func init() {
+	environ.RegisterDoctor(Name, Diagnose)
+}
+
+// Diagnose checks that vault is reachable and unsealed, that the configured auth method logs in, the TTL and
+// policies of the token, and that the token may read every key in VAULT_KV_KEYS and the AWS and GCP roles.
+// Stops at the first step the later ones depend on.
+func Diagnose() []environ.Check {
+	client, er := newClient()
+	if er != nil {
+		return []environ.Check{{Step: "config", Err: er, Hint: "check the VAULT_* environment variables"}}
+	}
+	checks := []environ.Check{{Step: "config", Detail: fmt.Sprintf("addr=%s", client.Address())}}
+
+	health, er := client.Sys().Health()
+	switch {
+	case er != nil:
+		return append(checks, environ.Check{Step: "health", Err: er, Hint: fmt.Sprintf("check that %s is reachable from here and, for https, that %s trusts its certificate", api.EnvVaultAddress, api.EnvVaultCACert)})
+	case !health.Initialized:
+		return append(checks, environ.Check{Step: "health", Err: fmt.Errorf("vault is not initialized"), Hint: "initialize vault, or point VAULT_ADDR at an initialized cluster"})
+	case health.Sealed:
+		return append(checks, environ.Check{Step: "health", Err: fmt.Errorf("vault is sealed"), Hint: "unseal vault"})
+	}
+	checks = append(checks, environ.Check{Step: "health", Detail: fmt.Sprintf("version=%s standby=%v", health.Version, health.Standby)})
+
+	if client.Token() != "" {
+		checks = append(checks, environ.Check{Step: "login", Detail: fmt.Sprintf("using %s", api.EnvVaultToken)})
+	} else {
+		if er := client.SetVaultToken(); er != nil {
+			return append(checks, environ.Check{Step: "login", Err: er, Hint: client.loginHint()})
+		}
+		checks = append(checks, environ.Check{Step: "login", Detail: fmt.Sprintf("method=%s path=%s", client.AuthMethod, client.AuthPath)})
+	}
+
+	token, er := client.Auth().Token().LookupSelf()
+	if er != nil {
+		return append(checks, environ.Check{Step: "token", Err: er, Hint: "the token is expired or revoked, log in again or issue a new token"})
+	}
+	ttl, _ := token.TokenTTL()
+	policies, _ := token.TokenPolicies()
+	checks = append(checks, environ.Check{Step: "token", Detail: fmt.Sprintf("ttl=%v policies=%s", ttl, strings.Join(policies, ","))})
+
+	for _, key := range client.Keys {
+		v2, v1, er := kvPaths(key.Path)
+		if er != nil {
+			checks = append(checks, environ.Check{Step: "read " + key.Path, Err: er, Hint: fmt.Sprintf("give %s as mount/path", EnvVaultKeys)})
+			continue
+		}
+		checks = append(checks, client.checkRead(key.Path, policies, v2, v1))
+	}
+	if !util.IsBlank(client.AwsRole) || !util.IsBlank(client.IamRole) {
+		role := client.AwsRole
+		if util.IsBlank(role) {
+			role = client.IamRole
+		}
+		path := strings.TrimSpace(strings.Trim(client.AwsPath, "/")) + "/sts/" + strings.TrimSpace(role)
+		checks = append(checks, client.checkRead(path, policies, path))
+	}
+	if !util.IsBlank(client.GcpRole) {
+		path := strings.TrimSpace(strings.Trim(client.GcpPath, "/")) + "/" + client.GcpCredType + "/" + strings.TrimSpace(client.GcpRole)
+		checks = append(checks, client.checkRead(path, policies, path))
+	}
+	return checks
+}
+
+// checkRead checks that the token may read at least one of the paths
+func (client *Client) checkRead(name string, policies []string, paths ...string) environ.Check {
+	check := environ.Check{Step: "read " + name}
+	for _, p := range paths {
+		caps, er := client.Sys().CapabilitiesSelf(p)
+		if er != nil {
+			check.Err = er
+			check.Hint = "the token may not look up its own capabilities, add sys/capabilities-self to its policies"
+			return check
+		}
+		for _, c := range caps {
+			if c == "read" || c == "root" {
+				check.Detail = fmt.Sprintf("path=%s capabilities=%s", p, strings.Join(caps, ","))
+				return check
+			}
+		}
+	}
+
+	check.Err = fmt.Errorf("permission denied")
+	check.Hint = fmt.Sprintf("grant read on %s to one of the token's policies (%s)", strings.Join(paths, " or "), strings.Join(policies, ","))
+	return check
+}
+
+// loginHint explains what to check when logging in with the configured auth method fails
+func (client *Client) loginHint() string {
+	switch {
+	case !util.IsBlank(client.AppRole) && !util.IsBlank(client.AppSecret):
+		return fmt.Sprintf("check that %s and %s are a valid role_id and secret_id for %s", EnvVaultAppRole, EnvVaultAppSecret, client.AuthPath)
+	case !util.IsBlank(client.AppRole) && !util.IsBlank(client.AppJWT):
+		return fmt.Sprintf("check that %s names a role at %s which accepts the issuer and audience of %s", EnvVaultAppRole, client.AuthPath, EnvVaultAppJWT)
+	case client.AuthData != nil:
+		return fmt.Sprintf("check that %s holds the fields %s expects", EnvVaultAuthData, client.AuthPath)
+	case inCluster():
+		return fmt.Sprintf("check that %s names a role at %s bound to this pod's service account and namespace", EnvVaultAppRole, client.AuthPath)
+	case os.Getenv(EnvKubernetesServiceHost) != "":
+		return fmt.Sprintf("the service account token %s is missing, mount it or set %s", kubernetesTokenFilePath, api.EnvVaultToken)
+	default:
+		return fmt.Sprintf("set %s, or %s with %s or %s, or %s", api.EnvVaultToken, EnvVaultAppRole, EnvVaultAppSecret, EnvVaultAppJWT, EnvVaultAuthData)
+	}
+}
@@ -0,0 +1,89 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func doctorServer(sealed bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		default:
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+		case "/v1/sys/health":
+			fmt.Fprintf(w, `{"initialized": true, "sealed": %v, "version": "1.2.3"}`, sealed)
+		case "/v1/auth/approle/login":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["secret_id"] != "good" {
+				http.Error(w, `{"errors":["invalid secret id"]}`, http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintln(w, vaultAuthResponse)
+		case "/v1/auth/token/lookup-self":
+			fmt.Fprintln(w, `{"data": {"ttl": 3600, "policies": ["default", "app"]}}`)
+		case "/v1/sys/capabilities-self":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			caps := `["deny"]`
+			if body["path"] == "secret/data/app" {
+				caps = `["read"]`
+			}
+			fmt.Fprintf(w, `{"data": {"capabilities": %s, %q: %s}}`, caps, body["path"], caps)
+		}
+	}))
+}
+
+func TestDiagnose(t *testing.T) {
+	tests := []struct {
+		name   string
+		sealed bool
+		envv   map[string]string
+		steps  []string
+		failed string
+	}{
+		{"readable", false, map[string]string{EnvVaultAppSecret: "good", EnvVaultKeys: "secret/app"}, []string{"config", "health", "login", "token", "read secret/app"}, ""},
+		{"denied", false, map[string]string{EnvVaultAppSecret: "good", EnvVaultKeys: "secret/app:secret/other"}, []string{"config", "health", "login", "token", "read secret/app", "read secret/other"}, "read secret/other"},
+		{"bad-login", false, map[string]string{EnvVaultAppSecret: "bad", EnvVaultKeys: "secret/app"}, []string{"config", "health", "login"}, "login"},
+		{"sealed", true, map[string]string{EnvVaultAppSecret: "good"}, []string{"config", "health"}, "health"},
+	}
+
+	currEnv := os.Environ()
+	defer func() {
+		os.Clearenv()
+		for _, item := range currEnv {
+			if parts := strings.SplitN(item, "=", 2); len(parts) == 2 {
+				os.Setenv(parts[0], parts[1])
+			}
+		}
+	}()
+
+	for _, tt := range tests {
+		ts := doctorServer(tt.sealed)
+		os.Clearenv()
+		os.Setenv("VAULT_ADDR", ts.URL)
+		os.Setenv(EnvVaultAppRole, "role")
+		for k, v := range tt.envv {
+			os.Setenv(k, v)
+		}
+
+		steps, failed := []string{}, ""
+		for _, c := range Diagnose() {
+			steps = append(steps, c.Step)
+			if c.Err != nil {
+				failed = c.Step
+				assert.NotEmptyf(t, c.Hint, tt.name)
+			}
+		}
+		assert.Equalf(t, tt.steps, steps, tt.name)
+		assert.Equalf(t, tt.failed, failed, tt.name)
+		ts.Close()
+	}
+}
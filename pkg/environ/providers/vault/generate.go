@@ -0,0 +1,145 @@
+package vault
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/lumoslabs/vestibule/pkg/log"
+)
+
+const (
+	defaultGenerateLength  = 32
+	defaultGenerateCharset = "base62"
+
+	// generateAttempts is how often a store is re-read and written when another starter wins the check-and-set
+	generateAttempts = 5
+)
+
+// charsets are the characters of the named charsets secrets may be generated from
+var charsets = map[string]string{
+	"alpha":     "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+	"numeric":   "0123456789",
+	"base62":    "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+	"hex":       "0123456789abcdef",
+	"base64":    "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/",
+	"base64url": "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_",
+	"printable": "!\"#$%&'()*+,-./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_`abcdefghijklmnopqrstuvwxyz{|}~",
+}
+
+func parseGenerators(path string) (interface{}, error) {
+	data, er := ioutil.ReadFile(path)
+	if er != nil {
+		return nil, er
+	}
+
+	gens := make(Generators)
+	if er := yaml.UnmarshalStrict(data, &gens); er != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", EnvVaultGenerateFile, er)
+	}
+	for name, g := range gens {
+		if g.Store == "" {
+			return nil, fmt.Errorf("no store for generated secret %s", name)
+		}
+		if g.Generate.Length == 0 {
+			g.Generate.Length = defaultGenerateLength
+		}
+		if g.Generate.Charset == "" {
+			g.Generate.Charset = defaultGenerateCharset
+		}
+		if g.Generate.Length < 0 {
+			return nil, fmt.Errorf("invalid length %d for generated secret %s", g.Generate.Length, name)
+		}
+		if _, ok := charsets[g.Generate.Charset]; !ok {
+			return nil, fmt.Errorf("unknown charset %s for generated secret %s", g.Generate.Charset, name)
+		}
+		gens[name] = g
+	}
+	return gens, nil
+}
+
+// generateSecrets returns the value of every generated secret, creating the ones missing from their store
+func (client *Client) generateSecrets() (map[string]string, error) {
+	stores := make(map[string][]string)
+	for name, g := range client.Generate {
+		stores[g.Store] = append(stores[g.Store], name)
+	}
+
+	values := make(map[string]string, len(client.Generate))
+	for store, names := range stores {
+		sort.Strings(names)
+		m, er := client.generateInStore(store, names)
+		if er != nil {
+			return nil, er
+		}
+		for k, v := range m {
+			values[k] = v
+		}
+	}
+	return values, nil
+}
+
+// generateInStore returns the named secrets from store, generating and writing back the missing ones. On KV v2 the
+// write is a check-and-set, and losing it means another starter wrote first, so the store is read again and its
+// values used.
+func (client *Client) generateInStore(store string, names []string) (map[string]string, error) {
+	var lastErr error
+	for i := 0; i < generateAttempts; i++ {
+		s, er := client.ReadKV(store)
+		if er != nil {
+			return nil, er
+		}
+
+		values := make(map[string]string, len(names))
+		data := make(map[string]interface{}, len(s.Data)+len(names))
+		for k, v := range s.Data {
+			data[k] = v
+		}
+		existing := s.Strings()
+		missing := make([]string, 0, len(names))
+		for _, name := range names {
+			// any value already in the store is kept, even one which is not a string
+			if _, ok := s.Data[name]; ok {
+				values[name] = existing[name]
+				continue
+			}
+
+			g := client.Generate[name].Generate
+			v, er := randomString(g.Length, charsets[g.Charset])
+			if er != nil {
+				return nil, er
+			}
+			values[name], data[name] = v, v
+			missing = append(missing, name)
+		}
+		if len(missing) == 0 {
+			return values, nil
+		}
+
+		log.Debugf("Writing generated secrets to vault. store=%s keys=%v version=%d", store, missing, s.Version)
+		if lastErr = client.WriteKV(s, data, s.V2()); lastErr == nil {
+			log.Infof("Generated secrets missing from vault. store=%s keys=%v", store, missing)
+			return values, nil
+		}
+		log.Debugf("Failed to write generated secrets, reading again. store=%s err=%v", store, lastErr)
+	}
+	return nil, fmt.Errorf("failed to write generated secrets to %s: %v", store, lastErr)
+}
+
+// randomString returns n characters picked uniformly from charset with crypto/rand
+func randomString(n int, charset string) (string, error) {
+	max := big.NewInt(int64(len(charset)))
+	b := make([]byte, n)
+	for i := range b {
+		idx, er := rand.Int(rand.Reader, max)
+		if er != nil {
+			return "", er
+		}
+		b[i] = charset[idx.Int64()]
+	}
+	return string(b), nil
+}
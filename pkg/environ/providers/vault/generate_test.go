@@ -0,0 +1,168 @@
+package vault
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lumoslabs/vestibule/pkg/environ"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	ts := kvServer()
+	defer ts.Close()
+
+	f, er := ioutil.TempFile("", "generate")
+	require.NoError(t, er)
+	defer os.Remove(f.Name())
+	f.WriteString(`
+SESSION_SECRET: {generate: {length: 64, charset: base62}, store: secret/app}
+PIN: {generate: {length: 6, charset: numeric}, store: secret/app}
+TOKEN: {store: secret/other}
+`)
+	f.Close()
+
+	os.Setenv("VAULT_ADDR", ts.URL)
+	os.Setenv("VAULT_TOKEN", vaultToken)
+	os.Setenv(EnvVaultGenerateFile, f.Name())
+	defer os.Unsetenv("VAULT_ADDR")
+	defer os.Unsetenv("VAULT_TOKEN")
+	defer os.Unsetenv(EnvVaultGenerateFile)
+
+	var (
+		wg       sync.WaitGroup
+		environs = make([]*environ.Environ, 4)
+	)
+	for i := range environs {
+		environs[i] = environ.New()
+		c, er := New()
+		require.NoError(t, er)
+
+		wg.Add(1)
+		go func(e *environ.Environ) {
+			defer wg.Done()
+			c.AddToEnviron(e)
+		}(environs[i])
+	}
+	wg.Wait()
+
+	first := environs[0].Map()
+	assert.Len(t, first["SESSION_SECRET"], 64)
+	assert.Regexp(t, `^[0-9A-Za-z]+$`, first["SESSION_SECRET"])
+	assert.Regexp(t, `^[0-9]{6}$`, first["PIN"])
+	assert.Len(t, first["TOKEN"], defaultGenerateLength)
+	for _, e := range environs[1:] {
+		assert.Equal(t, first, e.Map(), "concurrent starters agree")
+	}
+
+	client, er := NewClient()
+	require.NoError(t, er)
+	s, er := client.ReadKV("secret/app")
+	require.NoError(t, er)
+	assert.Equal(t, first["SESSION_SECRET"], s.Data["SESSION_SECRET"])
+}
+
+func TestGenerateExisting(t *testing.T) {
+	ts := kvServer()
+	defer ts.Close()
+
+	f, er := ioutil.TempFile("", "generate")
+	require.NoError(t, er)
+	defer os.Remove(f.Name())
+	f.WriteString(`
+PORT: {store: secret/app}
+FLAGS: {store: secret/app}
+`)
+	f.Close()
+
+	os.Setenv("VAULT_ADDR", ts.URL)
+	os.Setenv("VAULT_TOKEN", vaultToken)
+	os.Setenv(EnvVaultGenerateFile, f.Name())
+	defer os.Unsetenv("VAULT_ADDR")
+	defer os.Unsetenv("VAULT_TOKEN")
+	defer os.Unsetenv(EnvVaultGenerateFile)
+
+	client, er := NewClient()
+	require.NoError(t, er)
+	s, er := client.ReadKV("secret/app")
+	require.NoError(t, er)
+	require.NoError(t, client.WriteKV(s, map[string]interface{}{"PORT": json.Number("8080"), "FLAGS": nil}, true))
+
+	c, er := New()
+	require.NoError(t, er)
+	e := environ.New()
+	require.NoError(t, c.AddToEnviron(e))
+	assert.Equal(t, "8080", e.Map()["PORT"], "a value which is not a string is kept")
+	assert.Equal(t, "null", e.Map()["FLAGS"])
+
+	s, er = client.ReadKV("secret/app")
+	require.NoError(t, er)
+	assert.Equal(t, 1, s.Version, "nothing is written back")
+}
+
+func TestGenerateFailure(t *testing.T) {
+	kv := kvServer()
+	defer kv.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		kv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	f, er := ioutil.TempFile("", "generate")
+	require.NoError(t, er)
+	defer os.Remove(f.Name())
+	f.WriteString(`TOKEN: {store: secret/app}`)
+	f.Close()
+
+	os.Setenv("VAULT_ADDR", ts.URL)
+	os.Setenv("VAULT_TOKEN", vaultToken)
+	os.Setenv(EnvVaultGenerateFile, f.Name())
+	defer os.Unsetenv("VAULT_ADDR")
+	defer os.Unsetenv("VAULT_TOKEN")
+	defer os.Unsetenv(EnvVaultGenerateFile)
+
+	c, er := New()
+	require.NoError(t, er)
+	e := environ.New()
+	er = c.AddToEnviron(e)
+	if assert.Error(t, er) {
+		assert.Contains(t, er.Error(), "failed to generate secrets")
+	}
+	_, ok := e.Map()["TOKEN"]
+	assert.False(t, ok)
+}
+
+func TestParseGenerators(t *testing.T) {
+	tests := []struct {
+		doc, err string
+	}{
+		{`A: {generate: {length: 8}}`, "no store"},
+		{`A: {generate: {charset: emoji}, store: secret/app}`, "unknown charset"},
+		{`A: {generate: {length: -1}, store: secret/app}`, "invalid length"},
+		{`A: {generate: {size: 8}, store: secret/app}`, "failed to parse"},
+	}
+
+	for _, tt := range tests {
+		f, er := ioutil.TempFile("", "generate")
+		require.NoError(t, er)
+		f.WriteString(tt.doc)
+		f.Close()
+
+		_, er = parseGenerators(f.Name())
+		if assert.Errorf(t, er, tt.doc) {
+			assert.Truef(t, strings.Contains(er.Error(), tt.err), "%s: %v", tt.doc, er)
+		}
+		os.Remove(f.Name())
+	}
+}
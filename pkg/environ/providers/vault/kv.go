@@ -0,0 +1,130 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// KVSecret is a secret read from a KV v1 or v2 mount
+type KVSecret struct {
+	// Path is the path of the secret, without the data segment of KV v2
+	Path string
+	// Data holds the latest version of the secret, empty if it does not exist
+	Data map[string]interface{}
+	// Version is the latest version of the secret on KV v2, 0 if it does not exist or the mount is KV v1
+	Version int
+
+	mount string
+	v2    bool
+}
+
+// V2 is true if the secret lives in a KV v2 mount
+func (s *KVSecret) V2() bool {
+	return s.v2
+}
+
+// Strings returns the data of the secret as strings, with values which are not strings encoded as json
+func (s *KVSecret) Strings() map[string]string {
+	m := make(map[string]string, len(s.Data))
+	for k, v := range s.Data {
+		if str, ok := v.(string); ok {
+			m[k] = str
+		} else if b, er := json.Marshal(v); er == nil {
+			m[k] = string(b)
+		}
+	}
+	return m
+}
+
+// ReadKV reads the latest version of the secret at path, working out whether its mount is KV v1 or v2
+func (client *Client) ReadKV(path string) (*KVSecret, error) {
+	path = strings.Trim(slashRE.ReplaceAllString(path, "/"), "/")
+	if strings.Count(path, "/") < 1 {
+		return nil, ErrInvalidKVKey
+	}
+
+	s := &KVSecret{Path: path, Data: make(map[string]interface{})}
+	mount, v2, er := client.kvMount(path)
+	if er != nil {
+		return nil, er
+	}
+	s.mount, s.v2 = mount, v2
+
+	resp, er := client.Logical().Read(s.apiPath())
+	if er != nil {
+		return nil, er
+	}
+	if resp == nil || resp.Data == nil {
+		return s, nil
+	}
+	if !v2 {
+		s.Data = resp.Data
+		return s, nil
+	}
+
+	if data, ok := resp.Data["data"].(map[string]interface{}); ok {
+		s.Data = data
+	}
+	if meta, ok := resp.Data["metadata"].(map[string]interface{}); ok {
+		if v, ok := meta["version"].(json.Number); ok {
+			n, _ := v.Int64()
+			s.Version = int(n)
+		}
+	}
+	return s, nil
+}
+
+// WriteKV replaces the data of the secret. With cas the write fails if the secret changed since it was read, which
+// needs KV v2.
+func (client *Client) WriteKV(s *KVSecret, data map[string]interface{}, cas bool) error {
+	if !s.v2 {
+		if cas {
+			return fmt.Errorf("%s is in a KV v1 mount, which does not support check-and-set", s.Path)
+		}
+		_, er := client.Logical().Write(s.apiPath(), data)
+		return er
+	}
+
+	body := map[string]interface{}{"data": data}
+	if cas {
+		body["options"] = map[string]interface{}{"cas": s.Version}
+	}
+	resp, er := client.Logical().Write(s.apiPath(), body)
+	if er != nil {
+		return er
+	}
+	if resp != nil {
+		if v, ok := resp.Data["version"].(json.Number); ok {
+			n, _ := v.Int64()
+			s.Version = int(n)
+		}
+	}
+	s.Data = data
+	return nil
+}
+
+// apiPath is the path to read and write the secret, with the data segment on KV v2
+func (s *KVSecret) apiPath() string {
+	if !s.v2 {
+		return s.Path
+	}
+	return s.mount + "data/" + strings.TrimPrefix(s.Path, s.mount)
+}
+
+// kvMount returns the mount of path and whether it is KV v2. Vault versions without the mounts endpoint are taken to
+// be KV v1, as the vault cli does.
+func (client *Client) kvMount(path string) (string, bool, error) {
+	resp, er := client.Logical().Read("sys/internal/ui/mounts/" + path)
+	if er != nil {
+		return "", false, er
+	}
+	if resp == nil || resp.Data == nil {
+		return "", false, nil
+	}
+
+	mount, _ := resp.Data["path"].(string)
+	options, _ := resp.Data["options"].(map[string]interface{})
+	version, _ := options["version"].(string)
+	return mount, version == "2", nil
+}
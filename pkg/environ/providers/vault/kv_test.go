@@ -0,0 +1,116 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// kvServer mocks a KV v2 mount at secret/ and a KV v1 mount at kv/
+func kvServer() *httptest.Server {
+	var (
+		mu      sync.Mutex
+		v1      = map[string]map[string]interface{}{}
+		v2      = map[string]map[string]interface{}{}
+		version = map[string]int{}
+	)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		p := strings.TrimPrefix(r.URL.Path, "/v1/")
+		switch {
+		case strings.HasPrefix(p, "sys/internal/ui/mounts/secret/"):
+			fmt.Fprintln(w, `{"data": {"path": "secret/", "type": "kv", "options": {"version": "2"}}}`)
+		case strings.HasPrefix(p, "sys/internal/ui/mounts/kv/"):
+			fmt.Fprintln(w, `{"data": {"path": "kv/", "type": "kv", "options": null}}`)
+		case strings.HasPrefix(p, "secret/data/") && r.Method == http.MethodGet:
+			data, ok := v2[p]
+			if !ok {
+				http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+				return
+			}
+			b, _ := json.Marshal(data)
+			fmt.Fprintf(w, `{"data": {"data": %s, "metadata": {"version": %d}}}`, b, version[p])
+		case strings.HasPrefix(p, "secret/data/"):
+			var body struct {
+				Data    map[string]interface{}
+				Options map[string]int
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			if cas, ok := body.Options["cas"]; ok && cas != version[p] {
+				http.Error(w, `{"errors":["check-and-set parameter did not match the current version"]}`, http.StatusBadRequest)
+				return
+			}
+			v2[p] = body.Data
+			version[p]++
+			fmt.Fprintf(w, `{"data": {"version": %d}}`, version[p])
+		case strings.HasPrefix(p, "kv/") && r.Method == http.MethodGet:
+			data, ok := v1[p]
+			if !ok {
+				http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+				return
+			}
+			b, _ := json.Marshal(data)
+			fmt.Fprintf(w, `{"data": %s}`, b)
+		case strings.HasPrefix(p, "kv/"):
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			v1[p] = body
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+		}
+	}))
+}
+
+func TestKV(t *testing.T) {
+	ts := kvServer()
+	defer ts.Close()
+
+	os.Setenv("VAULT_ADDR", ts.URL)
+	os.Setenv("VAULT_TOKEN", vaultToken)
+	defer os.Unsetenv("VAULT_ADDR")
+	defer os.Unsetenv("VAULT_TOKEN")
+
+	client, er := NewClient()
+	require.NoError(t, er)
+
+	s, er := client.ReadKV("/secret//app/")
+	require.NoError(t, er)
+	assert.True(t, s.V2())
+	assert.Equal(t, "secret/app", s.Path)
+	assert.Equal(t, 0, s.Version)
+	assert.Empty(t, s.Data)
+
+	stale := *s
+	require.NoError(t, client.WriteKV(s, map[string]interface{}{"A": "1"}, true))
+	assert.Equal(t, 1, s.Version)
+	assert.Error(t, client.WriteKV(&stale, map[string]interface{}{"A": "2"}, true), "stale check-and-set")
+
+	s, er = client.ReadKV("secret/app")
+	require.NoError(t, er)
+	assert.Equal(t, 1, s.Version)
+	assert.Equal(t, map[string]string{"A": "1"}, s.Strings())
+
+	s, er = client.ReadKV("kv/app")
+	require.NoError(t, er)
+	assert.False(t, s.V2())
+	assert.Error(t, client.WriteKV(s, map[string]interface{}{"A": "1"}, true), "check-and-set on KV v1")
+	require.NoError(t, client.WriteKV(s, map[string]interface{}{"A": "1", "N": json.Number("2")}, false))
+
+	s, er = client.ReadKV("kv/app")
+	require.NoError(t, er)
+	assert.Equal(t, map[string]string{"A": "1", "N": "2"}, s.Strings())
+
+	_, er = client.ReadKV("app")
+	assert.Equal(t, ErrInvalidKVKey, er)
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
 	"reflect"
 	"regexp"
 	"strconv"
@@ -58,6 +57,30 @@ func New() (environ.Provider, error) {
 		}
 	}()
 
+	v, er := NewClient()
+	if er != nil {
+		return nil, er
+	}
+	return v, nil
+}
+
+// NewClient returns a Client configured from the environment and logged in to vault
+func NewClient() (*Client, error) {
+	v, er := newClient()
+	if er != nil {
+		return nil, er
+	}
+
+	if v.Token() == "" {
+		if er := v.SetVaultToken(); er != nil {
+			return nil, fmt.Errorf("vault login failed: %v", er)
+		}
+	}
+	return v, nil
+}
+
+// newClient returns a Client configured from the environment, without logging in
+func newClient() (*Client, error) {
 	log.Debugf("Creating vault api client. addr=%v", os.Getenv("VAULT_ADDR"))
 
 	vcc := api.DefaultConfig()
@@ -78,18 +101,13 @@ func New() (environ.Provider, error) {
 	p := env.CustomParsers{
 		reflect.TypeOf(KVKey{}):               parseVaultKVKey,
 		reflect.TypeOf(&RedactableAuthData{}): parseRedactableAuthData,
+		reflect.TypeOf(Generators{}):          parseGenerators,
 	}
 
 	if er := env.ParseWithFuncs(v, p); er != nil {
 		return nil, er
 	}
 	log.Debugf("Generated new vault client. client=%+v", v)
-
-	if v.Token() == "" {
-		if er := v.SetVaultToken(); er != nil {
-			return nil, fmt.Errorf("vault login failed: %v", er)
-		}
-	}
 	return v, nil
 }
 
@@ -182,7 +200,7 @@ func (client *Client) SetLoginPath() {
 }
 
 // AddToEnviron iterates through the given []VaultKeys, decoding the data returned from each key into a map[string]string
-// and merging it into the environ.Environ
+// and merging it into the environ.Environ. It fails if the generated secrets can not be read from or written to vault.
 func (client *Client) AddToEnviron(env *environ.Environ) error {
 	for _, ev := range sensitiveEnvVars {
 		env.Delete(ev)
@@ -221,16 +239,11 @@ func (client *Client) AddToEnviron(env *environ.Environ) error {
 				return
 			}
 
-			if er := client.writeAwsSharedFile(
-				creds[EnvAwsAccessKeyId],
-				creds[EnvAwsSecretAccessKey],
-				creds[EnvAwsSessionToken],
-			); er != nil {
-				log.Infof("Failed to write aws shared credentials file. file=%s err=%+v", client.AwsCredFile, er)
-				return
-			}
-
-			creds[EnvAwsSharedCredFile] = client.AwsCredFile
+			env.AddFile(environ.File{
+				Var:     EnvAwsSharedCredFile,
+				Path:    client.AwsCredFile,
+				Content: client.awsSharedFile(creds[EnvAwsAccessKeyId], creds[EnvAwsSecretAccessKey], creds[EnvAwsSessionToken]),
+			})
 			env.SafeMerge(creds)
 		}(p)
 	}
@@ -254,15 +267,30 @@ func (client *Client) AddToEnviron(env *environ.Environ) error {
 			case "token":
 				env.SafeMerge(map[string]string{EnvGoogleToken: creds["token"]})
 			case "key":
-				if er := client.writeGCPKeyFile(creds["private_key_data"]); er != nil {
-					log.Infof("Failed to write gcp credentials file. path=%s err=%+v", client.GcpCredFile, er)
+				key, er := base64.StdEncoding.DecodeString(creds["private_key_data"])
+				if er != nil {
+					log.Infof("Failed to decode gcp credentials. path=%s err=%+v", path, er)
 					return
 				}
-				env.SafeMerge(map[string]string{EnvGoogleCredFile: client.GcpCredFile})
+				env.AddFile(environ.File{Var: EnvGoogleCredFile, Path: client.GcpCredFile, Content: string(key)})
 			}
 		}(strings.TrimSpace(strings.Trim(client.GcpPath, "/")) + "/" + client.GcpCredType + "/" + strings.TrimSpace(client.GcpRole))
 	}
 
+	var generateErr error
+	if len(client.Generate) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			generated, er := client.generateSecrets()
+			if er != nil {
+				generateErr = fmt.Errorf("failed to generate secrets: %v", er)
+				return
+			}
+			env.SafeMerge(generated)
+		}()
+	}
+
 	if client.ExposeToken {
 		vaultToken := make(map[string]string)
 		vaultToken["VAULT_TOKEN"] = client.Token()
@@ -270,32 +298,15 @@ func (client *Client) AddToEnviron(env *environ.Environ) error {
 	}
 
 	wg.Wait()
-	return nil
+	return generateErr
 }
 
 func (client *Client) getKVData(key KVKey) (map[string]string, error) {
-	keyParts := strings.Split(key.Path, "/")
-	if len(keyParts) < 2 {
-		return nil, ErrInvalidKVKey
-	}
-
-	tail := len(keyParts) - 1
-	for i := 0; i <= tail; i++ {
-		if keyParts[i] == "" {
-			keyParts = append(keyParts[:i], keyParts[i+1:tail+1]...)
-			tail--
-			i--
-		}
-	}
-
-	// Add 'data' as the second path element if it does not exist
-	if keyParts[1] != "data" {
-		keyParts = append(keyParts, "")
-		copy(keyParts[2:], keyParts[1:])
-		keyParts[1] = "data"
+	reqPath, v1Path, er := kvPaths(key.Path)
+	if er != nil {
+		return nil, er
 	}
 
-	reqPath := strings.Join(keyParts, "/")
 	reqData := make(map[string][]string)
 	if key.Version != nil {
 		reqData["version"] = []string{strconv.Itoa(*(key.Version))}
@@ -307,7 +318,7 @@ func (client *Client) getKVData(key KVKey) (map[string]string, error) {
 	if er != nil || response == nil {
 		log.Debugf("Failed to get KVv2 secret from vault, trying KVv1. key=%s err=%v", reqPath, er)
 
-		reqPath = strings.Join(append(keyParts[:1], keyParts[2:]...), "/")
+		reqPath = v1Path
 		response, er := client.Logical().Read(reqPath)
 		if er != nil {
 			return nil, er
@@ -336,6 +347,33 @@ func (client *Client) getKVData(key KVKey) (map[string]string, error) {
 	return e, nil
 }
 
+// kvPaths returns the KV v2 data path and the KV v1 path of a key
+func kvPaths(path string) (string, string, error) {
+	keyParts := strings.Split(path, "/")
+	if len(keyParts) < 2 {
+		return "", "", ErrInvalidKVKey
+	}
+
+	tail := len(keyParts) - 1
+	for i := 0; i <= tail; i++ {
+		if keyParts[i] == "" {
+			keyParts = append(keyParts[:i], keyParts[i+1:tail+1]...)
+			tail--
+			i--
+		}
+	}
+
+	// Add 'data' as the second path element if it does not exist
+	if keyParts[1] != "data" {
+		keyParts = append(keyParts, "")
+		copy(keyParts[2:], keyParts[1:])
+		keyParts[1] = "data"
+	}
+
+	reqPath := strings.Join(keyParts, "/")
+	return reqPath, strings.Join(append(keyParts[:1], keyParts[2:]...), "/"), nil
+}
+
 func (client *Client) getAwsCreds(path string) (map[string]string, error) {
 	log.Debugf("Requesting aws credentials from vault. path=%s", path)
 	iam, er := client.Logical().Read(path)
@@ -360,16 +398,9 @@ func (client *Client) getAwsCreds(path string) (map[string]string, error) {
 	}, nil
 }
 
-func (client *Client) writeAwsSharedFile(accessKey, secretKey, sessionToken string) error {
-	var file afero.File
-	var content *ini.File
-
-	defer func() {
-		if file != nil {
-			file.Close()
-		}
-	}()
-
+// awsSharedFile returns the AWS shared credentials file with the credentials set in the profile, keeping any other
+// profiles of the existing file
+func (client *Client) awsSharedFile(accessKey, secretKey, sessionToken string) string {
 	content, er := ini.Load(client.AwsCredFile)
 	if er != nil {
 		content = ini.Empty()
@@ -383,22 +414,7 @@ func (client *Client) writeAwsSharedFile(accessKey, secretKey, sessionToken stri
 	}
 	buf := new(bytes.Buffer)
 	content.WriteTo(buf)
-
-	if er := fs.MkdirAll(filepath.Dir(client.AwsCredFile), 0755); er != nil {
-		return er
-	}
-
-	f, er := fs.Open(client.AwsCredFile)
-	if er != nil {
-		f, er = fs.Create(client.AwsCredFile)
-		if er != nil {
-			return er
-		}
-	}
-
-	f.Write(buf.Bytes())
-	f.Close()
-	return nil
+	return buf.String()
 }
 
 func (client *Client) getGCPCreds(path string) (map[string]string, error) {
@@ -421,28 +437,6 @@ func (client *Client) getGCPCreds(path string) (map[string]string, error) {
 	return data, nil
 }
 
-func (client *Client) writeGCPKeyFile(encoded string) error {
-	creds, er := base64.StdEncoding.DecodeString(encoded)
-	if er != nil {
-		return er
-	}
-
-	if er := fs.MkdirAll(filepath.Dir(client.GcpCredFile), 0755); er != nil {
-		return er
-	}
-
-	f, er := fs.Open(client.GcpCredFile)
-	if er != nil {
-		f, er = fs.Create(client.GcpCredFile)
-		if er != nil {
-			return er
-		}
-	}
-
-	f.Write([]byte(creds))
-	return f.Close()
-}
-
 func (vd *RedactableAuthData) toGenericMap() map[string]interface{} {
 	gm := make(map[string]interface{}, len(vd.data))
 	for k, v := range vd.data {
@@ -260,7 +260,11 @@ func TestAddToEnviron(t *testing.T) {
 
 		e := environ.New()
 		c.AddToEnviron(e)
-		assert.Equalf(t, test.envLen, e.Len(), `%d: vars=%v env=%v`, i, test.envv, e)
+		assert.Equalf(t, test.envLen, e.Len()+len(e.Files()), `%d: vars=%v env=%v`, i, test.envv, e)
+		files := make(map[string]environ.File)
+		for _, f := range e.Files() {
+			files[f.Var] = f
+		}
 
 		if _, ok := test.envv[EnvVaultKeys]; ok {
 			val, ok := e.Load("0")
@@ -273,9 +277,10 @@ func TestAddToEnviron(t *testing.T) {
 			assert.True(t, ok)
 			assert.Equalf(t, "aws-access-key", ak, `%d: vars=%v env=%v`, i, test.envv, e)
 
-			content, er := afero.ReadFile(fs, c.(*Client).AwsCredFile)
-			require.NoErrorf(t, er, `%d: vars=%v env=%v`, i, test.envv, e)
-			data, er := ini.Load(content)
+			f, ok := files[EnvAwsSharedCredFile]
+			require.Truef(t, ok, `%d: vars=%v env=%v`, i, test.envv, e)
+			assert.Equal(t, c.(*Client).AwsCredFile, f.Path)
+			data, er := ini.Load([]byte(f.Content))
 			require.NoErrorf(t, er, `%d: vars=%v env=%v`, i, test.envv, e)
 
 			assert.Equalf(t, "aws-access-key", data.Section("default").Key("aws_access_key_id").String(), `%d: vars=%v env=%v`, i, test.envv, e)
@@ -284,8 +289,10 @@ func TestAddToEnviron(t *testing.T) {
 		}
 
 		if role, ok := test.envv[EnvVaultGcpRole]; ok {
-			content, er := afero.ReadFile(fs, c.(*Client).GcpCredFile)
-			require.NoErrorf(t, er, `%d: vars=%v env=%v`, i, test.envv, e)
+			f, ok := files[EnvGoogleCredFile]
+			require.Truef(t, ok, `%d: vars=%v env=%v`, i, test.envv, e)
+			assert.Equal(t, c.(*Client).GcpCredFile, f.Path)
+			content := []byte(f.Content)
 			var data map[string]string
 			if role == "fail" {
 				er := json.Unmarshal(content, &data)
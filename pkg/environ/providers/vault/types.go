@@ -28,6 +28,7 @@ const (
 	EnvVaultGcpCredType      = "VAULT_GCP_CRED_TYPE"
 	EnvVaultGcpPath          = "VAULT_GCP_PATH"
 	EnvVaultGcpRole          = "VAULT_GCP_ROLE"
+	EnvVaultGenerateFile     = "VAULT_GENERATE_FILE"
 	EnvVaultKeys             = "VAULT_KV_KEYS"
 	EnvVestExposeVaultToken  = "VEST_VAULT_EXPOSE_TOKEN"
 )
@@ -60,7 +61,10 @@ the Pod's ServiceAccount token will automatically be looked up and used for Vaul
 e.g. VAULT_KV_KEYS=/path/to/key1[@version]:/path/to/key2[@version]:...`,
 		EnvVaultAwsRole: `Name of the aws role to generate credentials against. If credentials are returned, the access key and secret key will be injected into
 the process environment using the standard environment variables and a credentials file will be written to
-the path from AWS_SHARED_CREDENTIALS_FILE (by default "/var/run/aws/credentials")`,
+the path from AWS_SHARED_CREDENTIALS_FILE (by default "/var/run/aws/credentials"), or to the private secret files directory of a supervised command`,
+		EnvVaultGenerateFile: `YAML file of secrets to generate when missing from vault, written back to their store with
+check-and-set so concurrent starters agree on KV v2. Charsets are base62 (default), alpha, numeric, hex, base64, base64url and printable.
+e.g. SESSION_SECRET: {generate: {length: 64, charset: base62}, store: secret/app}`,
 		"VAULT_*":               "All vault client configuration environment variables are respected. More information at https://www.vaultproject.io/docs/commands/#environment-variables",
 		EnvVaultIamRole:         "[DEPRECATED] Name of the aws role to generate credentials against.",
 		EnvAwsProfile:           `AWS profile to use in the shared credentials file. Defaults to "default"`,
@@ -98,8 +102,21 @@ type Client struct {
 	GcpRole     string              `env:"VAULT_GCP_ROLE"`
 	GcpCredType string              `env:"VAULT_GCP_CRED_TYPE" envDefault:"key"`
 	GcpCredFile string              `env:"GOOGLE_CREDENTIALS_FILE" envDefault:"/var/run/gcp/creds.json"`
-	ExposeToken bool                `env:"VEST_VAULT_EXPOSE_TOKEN" envDefault:false`
+	ExposeToken bool                `env:"VEST_VAULT_EXPOSE_TOKEN" envDefault:"false"`
 	Keys        []KVKey             `env:"VAULT_KV_KEYS" envSeparator:":"`
+	Generate    Generators          `env:"VAULT_GENERATE_FILE"`
+}
+
+// Generators are the secrets to generate when missing from vault, by name
+type Generators map[string]Generator
+
+// Generator describes how to generate a secret and the KV path it is stored at
+type Generator struct {
+	Generate struct {
+		Length  int    `yaml:"length"`
+		Charset string `yaml:"charset"`
+	} `yaml:"generate"`
+	Store string `yaml:"store"`
 }
 
 // KVKeys is an alias for []*KVKey. Needed for caarlos0/env to support parsing.
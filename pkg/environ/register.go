@@ -6,7 +6,10 @@ import (
 	"github.com/lumoslabs/vestibule/pkg/log"
 )
 
-var providers map[string]ProviderFactory
+var (
+	providers map[string]ProviderFactory
+	doctors   map[string]Doctor
+)
 
 // RegisterProvider adds the named Provider's factory function to the map of known Providers
 func RegisterProvider(name string, fn ProviderFactory) {
@@ -27,6 +30,26 @@ func GetProvider(name string) (Provider, error) {
 	return fn()
 }
 
+// RegisterDoctor adds the named Provider's Doctor to the map of known Doctors
+func RegisterDoctor(name string, fn Doctor) {
+	if doctors == nil {
+		doctors = make(map[string]Doctor)
+	}
+	doctors[name] = fn
+}
+
+// Diagnose runs the named Provider's Doctor. Returns an unregistered provider error if the Provider is unknown, and
+// no checks if it has no Doctor.
+func Diagnose(name string) ([]Check, error) {
+	if _, ok := providers[name]; !ok {
+		return nil, newUnregisteredProviderError(name)
+	}
+	if fn, ok := doctors[name]; ok {
+		return fn(), nil
+	}
+	return nil, nil
+}
+
 func newUnregisteredProviderError(name string) *unregisteredProviderError {
 	return &unregisteredProviderError{name}
 }
@@ -0,0 +1,53 @@
+package environ
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// shellNameRE matches the names shells accept for environment variables
+var shellNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// posixQuoter quotes for sh, bash and zsh, where nothing is special inside single quotes, so a single quote ends the
+// string, is escaped, and starts a new one
+var posixQuoter = strings.NewReplacer(`'`, `'\''`)
+
+// fishQuoter quotes for fish, where backslash and single quote are escaped inside single quotes
+var fishQuoter = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// powershellQuoter quotes for PowerShell, where single quotes, including the typographic ones PowerShell also
+// accepts, are doubled inside single quotes
+var powershellQuoter = strings.NewReplacer(`'`, `''`, "‘", "‘‘", "’", "’’", "‚", "‚‚", "‛", "‛‛")
+
+func init() {
+	RegisterFormat("sh", encodeShell("export %s='%s'\n", posixQuoter), nil, "text/x-shellscript", ".sh")
+	RegisterFormat("bash", encodeShell("export %s='%s'\n", posixQuoter), nil, "text/x-shellscript", ".bash")
+	RegisterFormat("zsh", encodeShell("export %s='%s'\n", posixQuoter), nil, "text/x-shellscript", ".zsh")
+	RegisterFormat("fish", encodeShell("set -gx %s '%s'\n", fishQuoter), nil, "text/x-fish", ".fish")
+	RegisterFormat("powershell", encodeShell("$env:%s = '%s'\n", powershellQuoter), nil, "text/x-powershell", ".ps1")
+}
+
+// encodeShell returns an Encoder writing a line per secret, sorted by name, from the format given the name and the
+// value escaped with quoter. Multi-line values stay inside their quotes. Names a shell cannot set are an error.
+func encodeShell(format string, quoter *strings.Replacer) Encoder {
+	return func(w io.Writer, m map[string]string) error {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			if !shellNameRE.MatchString(k) {
+				return fmt.Errorf("%q is not a valid environment variable name", k)
+			}
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		buf := bufio.NewWriter(w)
+		for _, k := range keys {
+			fmt.Fprintf(buf, format, k, quoter.Replace(m[k]))
+		}
+		return buf.Flush()
+	}
+}
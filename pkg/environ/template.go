@@ -0,0 +1,87 @@
+package environ
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// Render executes text as a text/template against the key / value pairs of this Environ, writing the result to w.
+// Undefined keys render as an empty string, or are an error in strict mode.
+func (e *Environ) Render(w io.Writer, text string, strict bool) error {
+	return render(w, text, e.Map(), strict)
+}
+
+// RenderString executes text as a text/template against data and returns the result. Undefined keys render as an
+// empty string, or are an error in strict mode.
+func RenderString(text string, data map[string]string, strict bool) (string, error) {
+	buf := new(bytes.Buffer)
+	if er := render(buf, text, data, strict); er != nil {
+		return "", er
+	}
+	return buf.String(), nil
+}
+
+func render(w io.Writer, text string, data map[string]string, strict bool) error {
+	missingKey := "missingkey=zero"
+	if strict {
+		missingKey = "missingkey=error"
+	}
+
+	t, er := template.New("environ").Option(missingKey).Funcs(templateFuncs(data, strict)).Parse(text)
+	if er != nil {
+		return er
+	}
+	return t.Execute(w, data)
+}
+
+// templateFuncs returns the helpers available to templates
+//
+//	secret KEY          the value of KEY, which is an error in strict mode when undefined
+//	default DEF VALUE   VALUE, or DEF if VALUE is empty
+//	required MSG VALUE  VALUE, or an error with MSG if VALUE is empty
+//	base64Decode VALUE  the base64 decoded VALUE
+//	toJSON VALUE        VALUE as JSON
+//	indent N VALUE      VALUE with every line indented by N spaces
+//	env NAME            the value of the environment variable NAME
+func templateFuncs(data map[string]string, strict bool) template.FuncMap {
+	return template.FuncMap{
+		"secret": func(key string) (string, error) {
+			v, ok := data[key]
+			if !ok && strict {
+				return "", fmt.Errorf("secret %s is not defined", key)
+			}
+			return v, nil
+		},
+		"default": func(def, v string) string {
+			if v == "" {
+				return def
+			}
+			return v
+		},
+		"required": func(msg, v string) (string, error) {
+			if v == "" {
+				return "", fmt.Errorf("%s", msg)
+			}
+			return v, nil
+		},
+		"base64Decode": func(v string) (string, error) {
+			b, er := base64.StdEncoding.DecodeString(v)
+			return string(b), er
+		},
+		"toJSON": func(v interface{}) (string, error) {
+			b, er := json.Marshal(v)
+			return string(b), er
+		},
+		"indent": func(n int, v string) string {
+			pad := strings.Repeat(" ", n)
+			return pad + strings.Replace(v, "\n", "\n"+pad, -1)
+		},
+		"env": os.Getenv,
+	}
+}
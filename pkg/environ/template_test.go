@@ -0,0 +1,41 @@
+package environ
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderString(t *testing.T) {
+	data := map[string]string{"USER": "app", "PASS": "hunter2", "CERT": "LS0tCmNlcnQKLS0t", "EMPTY": ""}
+
+	tt := []struct {
+		text   string
+		strict bool
+		out    string
+		err    bool
+	}{
+		{"{{ .USER }}:{{ .PASS }}", false, "app:hunter2", false},
+		{"{{ .NOPE }}", false, "", false},
+		{"{{ .NOPE }}", true, "", true},
+		{`{{ secret "PASS" }}`, true, "hunter2", false},
+		{`{{ secret "NOPE" }}`, false, "", false},
+		{`{{ secret "NOPE" }}`, true, "", true},
+		{`{{ .EMPTY | default "5432" }}`, true, "5432", false},
+		{`{{ .USER | required "USER is required" }}`, true, "app", false},
+		{`{{ .EMPTY | required "EMPTY is required" }}`, true, "", true},
+		{`{{ .CERT | base64Decode }}`, true, "---\ncert\n---", false},
+		{`{{ .PASS | toJSON }}`, true, `"hunter2"`, false},
+		{`{{ .CERT | base64Decode | indent 2 }}`, true, "  ---\n  cert\n  ---", false},
+	}
+
+	for _, test := range tt {
+		out, er := RenderString(test.text, data, test.strict)
+		if test.err {
+			assert.Errorf(t, er, test.text)
+			continue
+		}
+		assert.NoErrorf(t, er, test.text)
+		assert.Equalf(t, test.out, out, test.text)
+	}
+}
@@ -1,6 +1,7 @@
 package environ
 
 import (
+	"io"
 	"regexp"
 	"sync"
 )
@@ -9,11 +10,26 @@ import (
 type Environ struct {
 	sync.RWMutex
 	m          map[string]string
+	sources    map[string]string
+	files      map[string]File
 	re         *regexp.Regexp
-	marshaller marshaller
+	marshaller Encoder
+	policy     Policy
+	policies   map[string]Policy
+	parent     *Environ
 	UpcaseKeys bool
 }
 
+// File is a secret its consumer reads from a file, such as a credentials file, rather than from the environment.
+// Providers add Files instead of writing them, leaving it to the consumer to put them in place.
+type File struct {
+	// Var is the variable set to the path of the file
+	Var string
+	// Path is where the file is written when the consumer does not put it somewhere of its own
+	Path    string
+	Content string
+}
+
 // Provider is a secrets provider able to inject variables into the environment
 type Provider interface {
 	AddToEnviron(*Environ) error
@@ -22,8 +38,34 @@ type Provider interface {
 // ProviderFactory is a func that returns a new Provider
 type ProviderFactory func() (Provider, error)
 
+// Check is the outcome of one step of diagnosing a Provider's configuration. Detail and Hint must never hold secret
+// values.
+type Check struct {
+	Step   string
+	Detail string
+	Err    error
+	Hint   string
+}
+
+// Doctor is a func that checks the configuration of a Provider from the environment, step by step
+type Doctor func() []Check
+
 type unregisteredProviderError struct {
 	provider string
 }
 
-type marshaller func(in interface{}) ([]byte, error)
+// Encoder writes secrets to w in a format. Encoders check every secret before writing, so an invalid one leaves w
+// untouched.
+type Encoder func(w io.Writer, m map[string]string) error
+
+// Decoder unmarshals secrets from a format
+type Decoder func(data []byte) (map[string]string, error)
+
+// Format is a registered format with its metadata
+type Format struct {
+	Name        string
+	ContentType string
+	Extensions  []string
+	Encode      Encoder
+	Decode      Decoder
+}
@@ -0,0 +1,74 @@
+package environ
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+func init() {
+	RegisterFormat("xml", encodeXML, decodeXML, "application/xml", ".xml")
+}
+
+// appSettings is the key / value layout of the appSettings section of a .NET app.config, which .NET reads from a
+// separate file when the section names it in its file attribute
+type appSettings struct {
+	XMLName  xml.Name     `xml:"appSettings"`
+	Settings []appSetting `xml:"add"`
+}
+
+type appSetting struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// encodeXML writes an <add key="..." value="..."/> element per secret, sorted by key, inside <appSettings>. New
+// lines and tabs in values are written as character references, so they survive attribute normalization. Characters
+// XML 1.0 has no place for, such as NUL, are an error.
+func encodeXML(w io.Writer, m map[string]string) error {
+	s := appSettings{Settings: make([]appSetting, 0, len(m))}
+	for _, k := range sortedNames(m) {
+		if !xmlText(k) || !xmlText(m[k]) {
+			return fmt.Errorf("%s has characters XML cannot hold", k)
+		}
+		s.Settings = append(s.Settings, appSetting{Key: k, Value: m[k]})
+	}
+
+	buf := bufio.NewWriter(w)
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(buf)
+	enc.Indent("", "  ")
+	if er := enc.Encode(s); er != nil {
+		return er
+	}
+	buf.WriteByte('\n')
+	return buf.Flush()
+}
+
+// xmlText returns whether s is valid UTF-8 made only of characters allowed in XML 1.0
+func xmlText(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' || r == 0xfffe || r == 0xffff {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeXML(data []byte) (map[string]string, error) {
+	var s appSettings
+	if er := xml.Unmarshal(data, &s); er != nil {
+		return nil, er
+	}
+
+	m := make(map[string]string, len(s.Settings))
+	for _, setting := range s.Settings {
+		m[setting.Key] = setting.Value
+	}
+	return m, nil
+}
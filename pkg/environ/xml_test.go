@@ -0,0 +1,37 @@
+package environ
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestXMLEncode(t *testing.T) {
+	out, er := Marshal("xml", map[string]string{"ConnectionString": `Server=db;Password="<p&ss>"`, "Multi": "a\nb\tc"})
+	require.NoError(t, er)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<appSettings>
+  <add key="ConnectionString" value="Server=db;Password=&#34;&lt;p&amp;ss&gt;&#34;"></add>
+  <add key="Multi" value="a&#xA;b&#x9;c"></add>
+</appSettings>
+`, string(out))
+
+	_, er = Marshal("xml", map[string]string{"NUL": "a\x00b"})
+	assert.Error(t, er)
+}
+
+func TestXMLDecode(t *testing.T) {
+	in := `<?xml version="1.0" encoding="utf-8"?>
+<!-- legacy settings -->
+<appSettings>
+  <add key="Env" value="prod" />
+  <add key="Greeting" value="héllo &#x1F600;" />
+</appSettings>`
+	out, er := Unmarshal("xml", []byte(in))
+	require.NoError(t, er)
+	assert.Equal(t, map[string]string{"Env": "prod", "Greeting": "héllo 😀"}, out)
+
+	_, er = Unmarshal("xml", []byte("<configuration/>"))
+	assert.Error(t, er)
+}
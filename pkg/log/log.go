@@ -2,8 +2,10 @@ package log
 
 import "fmt"
 
-// Logger is a simple interface that handles Info and Debug logging
+// Logger is a simple interface that handles Warn, Info and Debug logging
 type Logger interface {
+	Warn(string)
+	Warnf(string, ...interface{})
 	Info(string)
 	Infof(string, ...interface{})
 	Debug(string)
@@ -17,6 +19,12 @@ func GetLogger() Logger { return logger }
 // SetLogger sets the package logger
 func SetLogger(l Logger) { logger = l }
 
+// Warn writes warn level messages using the package logger
+func Warn(msg string) { logger.Warn(msg) }
+
+// Warnf writes formatted warn level messages with the package logger
+func Warnf(fmt string, inf ...interface{}) { logger.Warnf(fmt, inf...) }
+
 // Info writes info level messages using the package logger
 func Info(msg string) { logger.Info(msg) }
 
@@ -36,6 +44,8 @@ var logger Logger = NewNilLogger()
 
 type nilLogger bool
 
+func (nl *nilLogger) Warn(s string)                     {}
+func (nl *nilLogger) Warnf(f string, o ...interface{})  {}
 func (nl *nilLogger) Info(s string)                     {}
 func (nl *nilLogger) Infof(f string, o ...interface{})  {}
 func (nl *nilLogger) Debug(s string)                    {}
@@ -46,6 +56,8 @@ func NewNilLogger() Logger { return new(nilLogger) }
 
 type debugLogger bool
 
+func (dl *debugLogger) Warn(s string)                     { fmt.Println("[wrn] " + s) }
+func (dl *debugLogger) Warnf(f string, o ...interface{})  { fmt.Println(fmt.Sprintf("[wrn] "+f, o...)) }
 func (dl *debugLogger) Info(s string)                     { fmt.Println("[inf] " + s) }
 func (dl *debugLogger) Infof(f string, o ...interface{})  { fmt.Println(fmt.Sprintf("[inf] "+f, o...)) }
 func (dl *debugLogger) Debug(s string)                    { fmt.Println("[dbg] " + s) }